@@ -0,0 +1,272 @@
+package ccafct
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/heistp/fct/pretty"
+	"golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Arrival process models.
+const (
+	// ArrivalPoisson is a Poisson process, with exponentially distributed times
+	// between arrivals.
+	ArrivalPoisson = "poisson"
+
+	// ArrivalConstant is a constant rate process.
+	ArrivalConstant = "constant"
+
+	// ArrivalParetoOnOff alternates between on and off periods with Pareto
+	// distributed lengths. Arrivals are Poisson during on periods, and there
+	// are no arrivals during off periods.
+	ArrivalParetoOnOff = "pareto-onoff"
+
+	// ArrivalMMPP is a two-state Markov-modulated Poisson process, which
+	// alternates between high and low rate states with exponentially
+	// distributed sojourn times.
+	ArrivalMMPP = "mmpp"
+)
+
+var DefaultArrivalModel = ArrivalPoisson
+
+var DefaultParetoShape = 1.5
+
+var DefaultOnMean = 1 * time.Second
+
+var DefaultOffMean = 1 * time.Second
+
+var DefaultBurstRatio = 10.0
+
+var DefaultHighMean = 1 * time.Second
+
+var DefaultLowMean = 4 * time.Second
+
+// ArrivalParams contains the arrival process parameters. For all models, the
+// mean time between arrivals over the long term is Params.MeanArrival.
+type ArrivalParams struct {
+	// Model is the arrival process model (default poisson).
+	Model string
+
+	// ParetoShape is the shape parameter of the Pareto on and off period
+	// distributions for pareto-onoff. It must be greater than 1.
+	ParetoShape float64
+
+	// OnMean is the mean on period for pareto-onoff.
+	OnMean time.Duration
+
+	// OffMean is the mean off period for pareto-onoff.
+	OffMean time.Duration
+
+	// BurstRatio is the ratio of the arrival rate in the high state to the
+	// rate in the low state for mmpp.
+	BurstRatio float64
+
+	// HighMean is the mean sojourn time in the high rate state for mmpp.
+	HighMean time.Duration
+
+	// LowMean is the mean sojourn time in the low rate state for mmpp.
+	LowMean time.Duration
+}
+
+func (p *ArrivalParams) init() {
+	if p.Model == "" {
+		p.Model = DefaultArrivalModel
+	}
+	if p.ParetoShape == 0 {
+		p.ParetoShape = DefaultParetoShape
+	}
+	if p.OnMean == 0 {
+		p.OnMean = DefaultOnMean
+	}
+	if p.OffMean == 0 {
+		p.OffMean = DefaultOffMean
+	}
+	if p.BurstRatio == 0 {
+		p.BurstRatio = DefaultBurstRatio
+	}
+	if p.HighMean == 0 {
+		p.HighMean = DefaultHighMean
+	}
+	if p.LowMean == 0 {
+		p.LowMean = DefaultLowMean
+	}
+}
+
+// Validate returns an error if the parameters are invalid for the model. Zero
+// values are replaced by their defaults before validation.
+func (p ArrivalParams) Validate() error {
+	p.init()
+	switch p.Model {
+	case ArrivalPoisson, ArrivalConstant:
+	case ArrivalParetoOnOff:
+		if p.ParetoShape <= 1 {
+			return fmt.Errorf("Pareto shape must be > 1: %f", p.ParetoShape)
+		}
+		if p.OnMean <= 0 || p.OffMean <= 0 {
+			return fmt.Errorf("invalid on/off means: %s/%s", p.OnMean,
+				p.OffMean)
+		}
+	case ArrivalMMPP:
+		if p.BurstRatio < 1 {
+			return fmt.Errorf("burst ratio must be >= 1: %f", p.BurstRatio)
+		}
+		if p.HighMean <= 0 || p.LowMean <= 0 {
+			return fmt.Errorf("invalid high/low means: %s/%s", p.HighMean,
+				p.LowMean)
+		}
+	default:
+		return fmt.Errorf("unknown arrival model: '%s'", p.Model)
+	}
+	return nil
+}
+
+// Emit emits the parameters relevant to the model.
+func (p ArrivalParams) Emit(tw *pretty.TableWriter) {
+	tw.Printf("Arrival model:\t%s", p.Model)
+	switch p.Model {
+	case ArrivalParetoOnOff:
+		tw.Printf("|- Pareto shape:\t%s", pretty.Float64(p.ParetoShape, 3))
+		tw.Printf("|- Mean on:\t%s", p.OnMean)
+		tw.Printf("|- Mean off:\t%s", p.OffMean)
+	case ArrivalMMPP:
+		tw.Printf("|- Burst ratio:\t%s", pretty.Float64(p.BurstRatio, 3))
+		tw.Printf("|- Mean high:\t%s", p.HighMean)
+		tw.Printf("|- Mean low:\t%s", p.LowMean)
+	}
+}
+
+// ArrivalProcess generates the times between flow arrivals.
+type ArrivalProcess interface {
+	// Next returns the time until the next flow arrival.
+	Next() time.Duration
+}
+
+// NewArrivalProcess returns the ArrivalProcess for the test parameters, using
+// the given source of randomness.
+func NewArrivalProcess(p Params, src rand.Source) (ap ArrivalProcess,
+	err error) {
+	a := p.Arrival
+	a.init()
+	if err = a.Validate(); err != nil {
+		return
+	}
+	mean := float64(p.MeanArrival)
+
+	switch a.Model {
+	case ArrivalPoisson:
+		ap = &poissonProcess{
+			distuv.Exponential{Rate: p.ArrivalExpRate, Src: src},
+			mean,
+		}
+	case ArrivalConstant:
+		ap = constantProcess(p.MeanArrival)
+	case ArrivalParetoOnOff:
+		on, off := float64(a.OnMean), float64(a.OffMean)
+		onRate := (on + off) / on / mean
+		ap = &paretoOnOffProcess{
+			on:      paretoWithMean(on, a.ParetoShape, src),
+			off:     paretoWithMean(off, a.ParetoShape, src),
+			arrival: distuv.Exponential{Rate: onRate, Src: src},
+		}
+	case ArrivalMMPP:
+		high, low := float64(a.HighMean), float64(a.LowMean)
+		lowRate := (high + low) / (mean * (a.BurstRatio*high + low))
+		ap = &mmppProcess{
+			rate:    [2]float64{a.BurstRatio * lowRate, lowRate},
+			sojourn: [2]float64{high, low},
+			src:     src,
+		}
+	}
+
+	return
+}
+
+// poissonProcess is the ArrivalPoisson process.
+type poissonProcess struct {
+	dist distuv.Exponential
+	mean float64
+}
+
+func (p *poissonProcess) Next() time.Duration {
+	return time.Duration(p.dist.Rand() * p.mean)
+}
+
+// constantProcess is the ArrivalConstant process.
+type constantProcess time.Duration
+
+func (c constantProcess) Next() time.Duration {
+	return time.Duration(c)
+}
+
+// paretoWithMean returns a Pareto distribution with the given mean and shape.
+func paretoWithMean(mean, shape float64, src rand.Source) distuv.Pareto {
+	return distuv.Pareto{Xm: mean * (shape - 1) / shape, Alpha: shape,
+		Src: src}
+}
+
+// paretoOnOffProcess is the ArrivalParetoOnOff process. Times are in
+// nanoseconds.
+type paretoOnOffProcess struct {
+	on      distuv.Pareto
+	off     distuv.Pareto
+	arrival distuv.Exponential
+	remain  float64
+	started bool
+}
+
+func (p *paretoOnOffProcess) Next() time.Duration {
+	if !p.started {
+		p.remain = p.on.Rand()
+		p.started = true
+	}
+	var t float64
+	for {
+		w := p.arrival.Rand()
+		if w <= p.remain {
+			p.remain -= w
+			return time.Duration(t + w)
+		}
+		t += p.remain + p.off.Rand()
+		p.remain = p.on.Rand()
+	}
+}
+
+// mmppProcess is the ArrivalMMPP process, where state 0 is high and state 1 is
+// low. Times are in nanoseconds, and rates in arrivals per nanosecond.
+type mmppProcess struct {
+	rate    [2]float64
+	sojourn [2]float64
+	state   int
+	remain  float64
+	started bool
+	src     rand.Source
+}
+
+// exp returns an exponentially distributed value with the given mean.
+func (m *mmppProcess) exp(mean float64) float64 {
+	return distuv.Exponential{Rate: 1 / mean, Src: m.src}.Rand()
+}
+
+func (m *mmppProcess) Next() time.Duration {
+	if !m.started {
+		m.remain = m.exp(m.sojourn[m.state])
+		m.started = true
+	}
+	var t float64
+	for {
+		w := math.Inf(1)
+		if m.rate[m.state] > 0 {
+			w = m.exp(1 / m.rate[m.state])
+		}
+		if w <= m.remain {
+			m.remain -= w
+			return time.Duration(t + w)
+		}
+		t += m.remain
+		m.state ^= 1
+		m.remain = m.exp(m.sojourn[m.state])
+	}
+}
@@ -0,0 +1,83 @@
+package ccafct
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+func TestArrivalProcessMean(t *testing.T) {
+	const mean = 10 * time.Millisecond
+	const n = 1000000
+	for _, m := range []string{ArrivalPoisson, ArrivalConstant,
+		ArrivalParetoOnOff, ArrivalMMPP} {
+		p := Params{MeanArrival: mean, Arrival: ArrivalParams{Model: m}}
+		p.init()
+		ap, err := NewArrivalProcess(p, rand.NewSource(1))
+		if err != nil {
+			t.Fatalf("%s: %s", m, err)
+		}
+		var total time.Duration
+		for i := 0; i < n; i++ {
+			total += ap.Next()
+		}
+		got := total / n
+		if e := math.Abs(float64(got-mean)) / float64(mean); e > 0.05 {
+			t.Errorf("%s: mean arrival %s, want %s", m, got, mean)
+		}
+	}
+}
+
+func TestArrivalProcessBursty(t *testing.T) {
+	// the coefficient of variation of the times between arrivals is 1 for a
+	// Poisson process, and greater for the bursty models
+	const n = 200000
+	for _, c := range []struct {
+		model string
+		minCV float64
+	}{
+		{ArrivalConstant, 0},
+		{ArrivalPoisson, 0.95},
+		{ArrivalParetoOnOff, 1.2},
+		{ArrivalMMPP, 1.2},
+	} {
+		p := Params{MeanArrival: 10 * time.Millisecond,
+			Arrival: ArrivalParams{Model: c.model}}
+		p.init()
+		ap, err := NewArrivalProcess(p, rand.NewSource(2))
+		if err != nil {
+			t.Fatalf("%s: %s", c.model, err)
+		}
+		var sum, sum2 float64
+		for i := 0; i < n; i++ {
+			x := float64(ap.Next())
+			sum += x
+			sum2 += x * x
+		}
+		m := sum / n
+		cv := math.Sqrt(sum2/n-m*m) / m
+		if c.minCV == 0 && cv > 1e-9 || cv < c.minCV {
+			t.Errorf("%s: coefficient of variation %.3f, want >= %.2f",
+				c.model, cv, c.minCV)
+		}
+	}
+}
+
+func TestArrivalParamsValidate(t *testing.T) {
+	for _, p := range []ArrivalParams{
+		{Model: "bursty"},
+		{Model: ArrivalParetoOnOff, ParetoShape: 1},
+		{Model: ArrivalParetoOnOff, OnMean: -time.Second},
+		{Model: ArrivalMMPP, BurstRatio: 0.5},
+		{Model: ArrivalMMPP, LowMean: -time.Second},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", p)
+		}
+	}
+	if err := (ArrivalParams{}).Validate(); err != nil {
+		t.Errorf("Validate rejected the defaults: %s", err)
+	}
+}
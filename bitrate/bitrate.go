@@ -2,6 +2,9 @@ package bitrate
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	"github.com/heistp/fct/pretty"
 )
@@ -24,6 +27,23 @@ var qdiscUnits = map[string]string{
 	"T": "Tbit",
 }
 
+// parseUnits maps lower case unit suffixes to their multipliers.
+var parseUnits = []struct {
+	suffix string
+	mult   Bitrate
+}{
+	{"tbps", Tbps},
+	{"tbit", Tbps},
+	{"gbps", Gbps},
+	{"gbit", Gbps},
+	{"mbps", Mbps},
+	{"mbit", Mbps},
+	{"kbps", Kbps},
+	{"kbit", Kbps},
+	{"bps", BPS},
+	{"bit", BPS},
+}
+
 var stdUnits = map[string]string{
 	"K": "Kbps",
 	"M": "Mbps",
@@ -86,3 +106,27 @@ func (b Bitrate) format(units map[string]string) string {
 		return pretty.Float64(b.Tbps(), 3) + units["T"]
 	}
 }
+
+// Parse parses a Bitrate from a string such as "50Mbps" or "1.5Gbit". Both the
+// standard and qdisc units are accepted, case insensitively. A string without
+// units is in bits per second. Negative, infinite and out of range values are
+// rejected.
+func Parse(s string) (b Bitrate, err error) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	mult := BPS
+	for _, u := range parseUnits {
+		if strings.HasSuffix(t, u.suffix) {
+			t = strings.TrimSpace(strings.TrimSuffix(t, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	var f float64
+	if f, err = strconv.ParseFloat(t, 64); err != nil || math.IsNaN(f) ||
+		f < 0 || f*float64(mult) >= math.MaxInt64 {
+		err = fmt.Errorf("invalid bitrate: '%s'", s)
+		return
+	}
+	b = Bitrate(f * float64(mult))
+	return
+}
@@ -0,0 +1,62 @@
+package bitrate
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want Bitrate
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"1000", 1000 * BPS, true},
+		{"5e7", 50 * Mbps, true},
+		{"100bps", 100 * BPS, true},
+		{"100bit", 100 * BPS, true},
+		{"64Kbps", 64 * Kbps, true},
+		{"64kbit", 64 * Kbps, true},
+		{"50Mbps", 50 * Mbps, true},
+		{"50mbit", 50 * Mbps, true},
+		{" 50 MBPS ", 50 * Mbps, true},
+		{"1.5Gbit", 1500 * Mbps, true},
+		{"10gbps", 10 * Gbps, true},
+		{"1Tbps", 1 * Tbps, true},
+		{"", 0, false},
+		{"Mbps", 0, false},
+		{"50Mbs", 0, false},
+		{"50Mb", 0, false},
+		{"50M", 0, false},
+		{"50xbps", 0, false},
+		{"50Mbpss", 0, false},
+		{"-1Mbps", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"1e30bps", 0, false},
+	} {
+		b, err := Parse(c.s)
+		if !c.ok {
+			if err == nil {
+				t.Errorf("Parse(%q) = %d, want error", c.s, b)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %s", c.s, err)
+		} else if b != c.want {
+			t.Errorf("Parse(%q) = %d, want %d", c.s, b, c.want)
+		}
+	}
+}
+
+func TestParseFormatted(t *testing.T) {
+	for _, b := range []Bitrate{100 * BPS, 64 * Kbps, 50 * Mbps,
+		1500 * Mbps, 10 * Gbps} {
+		for _, s := range []string{b.String(), b.Qdisc()} {
+			if p, err := Parse(s); err != nil {
+				t.Errorf("Parse(%q): %s", s, err)
+			} else if p != b {
+				t.Errorf("Parse(%q) = %d, want %d", s, p, b)
+			}
+		}
+	}
+}
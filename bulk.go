@@ -0,0 +1,661 @@
+package ccafct
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/heistp/fct/bitrate"
+)
+
+// The bulk protocol runs long-lived flows, e.g. to compete with an FCT
+// workload. For each flow, the client opens a TCP connection and sends a
+// request header with the same layout as the raw protocol's, except that the
+// magic is "FB", length is the sample interval in nanoseconds, and id is the
+// flow number. The server replies with a raw protocol status byte, then
+// the flow runs until the client closes the connection.
+//
+// The server sends frames to the client, each with a uint8 frame type and a
+// uint32 big endian payload length. For downloads, bulkFrameData frames carry
+// the payload. For both directions, a bulkFrameSample frame is sent each
+// sample interval, with a bulkSample in JSON. For uploads, the client sends
+// the payload unframed.
+
+// bulkMagic identifies bulk protocol requests.
+var bulkMagic = [2]byte{'F', 'B'}
+
+// bulkFrameHeaderLen is the length of the bulk frame header.
+const bulkFrameHeaderLen = 5
+
+// Bulk frame types.
+const (
+	bulkFrameData   = 0
+	bulkFrameSample = 1
+)
+
+// DefaultBulkListenAddr is the default listen address for the bulk server.
+var DefaultBulkListenAddr = fmt.Sprintf(":%d", DefaultBulkPort)
+
+// DefaultBulkFlows is the default number of bulk flows.
+var DefaultBulkFlows = 1
+
+// DefaultBulkInterval is the default bulk sample interval.
+var DefaultBulkInterval = 1 * time.Second
+
+// bulkSample is a sample taken by the bulk server, sent in sample frames.
+type bulkSample struct {
+	// Time is the time since the flow started.
+	Time time.Duration
+
+	// Bytes is the total number of payload bytes sent or received.
+	Bytes int64
+
+	// TCPInfo is the server's TCP_INFO, or nil if unavailable.
+	TCPInfo *TCPInfo `json:",omitempty"`
+}
+
+// BulkSample is one sample of a bulk flow.
+type BulkSample struct {
+	// Flow is the flow number, from zero.
+	Flow int
+
+	// CCA is the flow's congestion control algorithm.
+	CCA string
+
+	// Direction is the flow direction, either download or upload.
+	Direction string
+
+	// Time is when the sample was taken by the client.
+	Time time.Time
+
+	// Interval is the time since the previous sample, or since the flow
+	// started for the first sample.
+	Interval time.Duration
+
+	// Bytes is the number of payload bytes received during the interval.
+	Bytes int64
+
+	// Throughput is the receive throughput during the interval.
+	Throughput bitrate.Bitrate
+
+	// TCPInfo is the sender's TCP_INFO at the end of the interval, or nil if
+	// unavailable.
+	TCPInfo *TCPInfo `json:",omitempty"`
+}
+
+// newBulkSample returns a new BulkSample for a flow, with the throughput
+// calculated from the bytes received during the interval.
+func newBulkSample(f bulkFlow, interval time.Duration, bytes int64,
+	info *TCPInfo) (s BulkSample) {
+	dir := Download
+	if f.upload {
+		dir = Upload
+	}
+	s = BulkSample{f.n, f.cca, dir, time.Now(), interval, bytes, 0, info}
+	if interval > 0 {
+		s.Throughput = bitrate.Bitrate(float64(bytes) * 8 /
+			interval.Seconds())
+	}
+	return
+}
+
+// ReadBulkSamples reads BulkSamples in NDJSON form.
+func ReadBulkSamples(r io.Reader) (samples []BulkSample, err error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var s BulkSample
+		if err = dec.Decode(&s); err == io.EOF {
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+		samples = append(samples, s)
+	}
+}
+
+// BulkServer is the bulk flow server.
+type BulkServer struct {
+	// ListenAddr is the listen address (default :8190).
+	ListenAddr string
+
+	// Listener, if set, is an existing listener to serve on, instead of
+	// listening on ListenAddr.
+	Listener net.Listener
+
+	// BufLen is the length of the buffer for writing data frames.
+	BufLen int
+
+	buf    []byte
+	conns  map[net.Conn]struct{}
+	wg     sync.WaitGroup
+	closed bool
+	mtx    sync.Mutex
+}
+
+// init initializes the BulkServer struct.
+func (s *BulkServer) init() {
+	if s.ListenAddr == "" {
+		s.ListenAddr = DefaultBulkListenAddr
+	}
+
+	if s.BufLen == 0 {
+		s.BufLen = DefaultBufLen
+	}
+
+	s.buf = make([]byte, s.BufLen)
+	s.buf[0] = bulkFrameData
+	binary.BigEndian.PutUint32(s.buf[1:bulkFrameHeaderLen],
+		uint32(s.BufLen-bulkFrameHeaderLen))
+	s.conns = make(map[net.Conn]struct{})
+}
+
+// Run runs the server until ctx is done, then shuts it down. It returns nil
+// after a shutdown, or an error if the server couldn't be started or failed.
+// A BulkServer may only be run once.
+func (s *BulkServer) Run(ctx context.Context) (err error) {
+	s.mtx.Lock()
+	if s.conns != nil || s.closed {
+		s.mtx.Unlock()
+		return fmt.Errorf("bulk server already run or shut down")
+	}
+	s.init()
+	if s.Listener == nil {
+		if s.Listener, err = net.Listen("tcp", s.ListenAddr); err != nil {
+			s.mtx.Unlock()
+			return
+		}
+	}
+	s.mtx.Unlock()
+
+	log.Printf("bulk server listening on %s", s.Listener.Addr())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.serve(s.Listener)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+	s.Shutdown()
+	return
+}
+
+// Shutdown shuts down the server, closing its listener and active flows, and
+// waits for them to end. Bulk flows have no natural end, so there is no
+// graceful shutdown.
+func (s *BulkServer) Shutdown() {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return
+	}
+	s.closed = true
+	if s.Listener != nil {
+		s.Listener.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mtx.Unlock()
+	s.wg.Wait()
+}
+
+// serve accepts bulk connections until the listener is closed. It returns nil
+// if the server was shut down. Temporary Accept errors are logged and retried.
+func (s *BulkServer) serve(l net.Listener) error {
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mtx.Lock()
+			closed := s.closed
+			s.mtx.Unlock()
+			if closed {
+				return nil
+			}
+			if delay = acceptRetry(err, delay); delay > 0 {
+				log.Printf("bulk accept error: '%s', retrying in %s", err,
+					delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		tc, ok := c.(*net.TCPConn)
+		if !ok {
+			log.Printf("bulk conn not TCP: '%v'", c)
+			c.Close()
+			continue
+		}
+		if !s.track(tc, true) {
+			tc.Close()
+			continue
+		}
+		go func() {
+			defer s.track(tc, false)
+			s.handle(tc)
+		}()
+	}
+}
+
+// track adds or removes an active connection, and updates wg. It returns
+// false if the connection can't be added because the server was shut down.
+func (s *BulkServer) track(c net.Conn, add bool) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !add {
+		delete(s.conns, c)
+		s.wg.Done()
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+// handle handles one bulk connection. Errors are expected when the client
+// closes the connection to end the flow, so they're not logged.
+func (s *BulkServer) handle(c *net.TCPConn) {
+	defer c.Close()
+
+	var h rawHeader
+	var err error
+	if err = binary.Read(c, binary.BigEndian, &h); err != nil {
+		log.Printf("bulk read error: '%s'", err)
+		return
+	}
+	if err = h.validate(bulkMagic); err != nil {
+		rawError(c, err)
+		return
+	}
+	interval := time.Duration(h.Length)
+	if interval <= 0 {
+		rawError(c, fmt.Errorf("invalid bulk sample interval: %d", h.Length))
+		return
+	}
+
+	if err = applySockOpts(h.sockOpts(), func(f func(fd int) error) error {
+		return connControl(c, f)
+	}); err != nil {
+		rawError(c, err)
+		return
+	}
+
+	if _, err = c.Write([]byte{rawStatusOK}); err != nil {
+		return
+	}
+	if h.Flags&rawFlagUpload != 0 {
+		s.receive(c, interval)
+	} else {
+		s.send(c, interval)
+	}
+}
+
+// send sends data frames to the client, with a sample frame each interval.
+func (s *BulkServer) send(c *net.TCPConn, interval time.Duration) {
+	start := time.Now()
+	next := start.Add(interval)
+	var n int64
+	for {
+		if _, err := c.Write(s.buf); err != nil {
+			return
+		}
+		n += int64(s.BufLen - bulkFrameHeaderLen)
+		if now := time.Now(); !now.Before(next) {
+			if err := writeBulkSample(c, now.Sub(start), n); err != nil {
+				return
+			}
+			next = now.Add(interval)
+		}
+	}
+}
+
+// receive receives and discards the payload from the client, and sends a
+// sample frame each interval.
+func (s *BulkServer) receive(c *net.TCPConn, interval time.Duration) {
+	start := time.Now()
+	var n int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b := make([]byte, s.BufLen)
+		for {
+			r, err := c.Read(b)
+			atomic.AddInt64(&n, int64(r))
+			if err != nil {
+				return
+			}
+		}
+	}()
+	defer func() {
+		c.Close()
+		<-done
+	}()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-t.C:
+			if err := writeBulkSample(c, now.Sub(start),
+				atomic.LoadInt64(&n)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeBulkSample writes a sample frame, with the connection's TCP_INFO.
+func writeBulkSample(c *net.TCPConn, t time.Duration, n int64) (err error) {
+	ss := bulkSample{Time: t, Bytes: n}
+	if info, e := getConnTCPInfo(c); e != nil {
+		log.Printf("unable to get TCP_INFO: '%s'", e)
+	} else {
+		ss.TCPInfo = &info
+	}
+	var j []byte
+	if j, err = json.Marshal(ss); err != nil {
+		return
+	}
+	b := make([]byte, bulkFrameHeaderLen, bulkFrameHeaderLen+len(j))
+	b[0] = bulkFrameSample
+	binary.BigEndian.PutUint32(b[1:], uint32(len(j)))
+	_, err = c.Write(append(b, j...))
+	return
+}
+
+// Bulk contains the parameters for bulk flows, run by a bulk client.
+type Bulk struct {
+	// Addr is the bulk server address, with the port defaulting to
+	// DefaultBulkPort.
+	Addr string
+
+	// Flows is the number of parallel flows (default DefaultBulkFlows).
+	Flows int
+
+	// CCA is the congestion control algorithm (default DefaultCCA).
+	CCA string
+
+	// Direction is the flow direction, either download (the default) or
+	// upload.
+	Direction string
+
+	// Spec, if set, lists the flows to run, and is used instead of Flows and
+	// CCA. Groups without a direction use Direction.
+	Spec BulkSpec
+
+	// ServerSockOpts are the socket options the server sets on its sockets.
+	// The CCA field is ignored, and the flow's CCA is used instead.
+	ServerSockOpts SockOpts
+
+	// ClientSockOpts are the socket options set on the client's sockets. If
+	// ClientSockOpts.CCA is empty, the flow's CCA is used.
+	ClientSockOpts SockOpts
+
+	// Duration is how long to run. If zero, flows run until the context is
+	// done.
+	Duration time.Duration
+
+	// Interval is the sample interval (default DefaultBulkInterval).
+	Interval time.Duration
+}
+
+// init sets defaults and validates the parameters.
+func (b *Bulk) init() (err error) {
+	if b.Addr == "" {
+		b.Addr = DefaultAddr
+	}
+	if !strings.Contains(b.Addr, ":") {
+		b.Addr = fmt.Sprintf("%s:%d", b.Addr, DefaultBulkPort)
+	}
+	if b.Flows == 0 {
+		b.Flows = DefaultBulkFlows
+	}
+	if b.CCA == "" {
+		b.CCA = DefaultCCA
+	}
+	if b.Direction == "" {
+		b.Direction = DefaultDirection
+	}
+	if b.Interval == 0 {
+		b.Interval = DefaultBulkInterval
+	}
+	if b.Flows < 0 {
+		err = fmt.Errorf("invalid number of bulk flows: %d", b.Flows)
+		return
+	}
+	if b.Interval < 0 {
+		err = fmt.Errorf("invalid bulk sample interval: %s", b.Interval)
+		return
+	}
+	if b.Direction != Download && b.Direction != Upload {
+		err = fmt.Errorf("unknown direction: '%s'", b.Direction)
+		return
+	}
+	if b.Spec == nil {
+		b.Spec = BulkSpec{{Count: b.Flows, CCA: b.CCA}}
+	}
+	if err = b.Spec.Validate(); err != nil {
+		return
+	}
+	if err = b.ServerSockOpts.Validate(); err != nil {
+		return
+	}
+	err = b.ClientSockOpts.Validate()
+	return
+}
+
+// Run runs the flows until Duration elapses or ctx is done, calling sample
+// for each sample. Calls to sample are serialized. An error is returned if
+// any flow fails before then.
+func (b *Bulk) Run(ctx context.Context, sample func(BulkSample)) (
+	err error) {
+	if err = b.init(); err != nil {
+		return
+	}
+	var cancel context.CancelFunc
+	if b.Duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.Duration)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	var mtx sync.Mutex
+	emit := func(s BulkSample) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		sample(s)
+	}
+	errCh := make(chan error, b.Spec.Flows())
+	var wg sync.WaitGroup
+	var n int
+	for _, g := range b.Spec {
+		dir := g.Direction
+		if dir == "" {
+			dir = b.Direction
+		}
+		for i := 0; i < g.Count; i++ {
+			f := bulkFlow{n, g.CCA, dir == Upload,
+				g.Start + time.Duration(i)*g.Every, g.Duration}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if e := b.runFlow(ctx, f, emit); e != nil {
+					errCh <- e
+					cancel()
+				}
+			}()
+			n++
+		}
+	}
+	wg.Wait()
+
+	select {
+	case err = <-errCh:
+	default:
+	}
+	return
+}
+
+// bulkFlow contains the parameters for one bulk flow.
+type bulkFlow struct {
+	n      int
+	cca    string
+	upload bool
+	start  time.Duration
+	dur    time.Duration
+}
+
+// runFlow waits until the flow's start time, then runs it until its duration
+// elapses or ctx is done.
+func (b *Bulk) runFlow(ctx context.Context, f bulkFlow,
+	emit func(BulkSample)) (err error) {
+	if f.start > 0 {
+		t := time.NewTimer(f.start)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+	if f.dur > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.dur)
+		defer cancel()
+	}
+	defer func() {
+		if ctx.Err() != nil {
+			err = nil
+		}
+	}()
+
+	upload := f.upload
+	o := b.ServerSockOpts
+	o.CCA = f.cca
+	var h rawHeader
+	if h, err = newRawHeader(uint64(f.n), int(b.Interval), o,
+		upload); err != nil {
+		return
+	}
+	h.Magic = bulkMagic
+
+	co := b.ClientSockOpts
+	if co.CCA == "" {
+		co.CCA = f.cca
+	}
+	d := &net.Dialer{Control: co.Control}
+	var nc net.Conn
+	if nc, err = d.DialContext(ctx, "tcp", b.Addr); err != nil {
+		return
+	}
+	c := nc.(*net.TCPConn)
+	defer c.Close()
+
+	// close the connection when the context is done, which ends the flow
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	if err = binary.Write(c, binary.BigEndian, &h); err != nil {
+		return
+	}
+	br := bufio.NewReaderSize(c, DefaultBufLen)
+	var status byte
+	if status, err = br.ReadByte(); err != nil {
+		return
+	}
+	if status != rawStatusOK {
+		err = readRawError(br)
+		return
+	}
+	start := time.Now()
+
+	if upload {
+		go io.Copy(c, zeroReader{})
+	}
+
+	var hdr [bulkFrameHeaderLen]byte
+	var n, prevN int64
+	var prevT time.Duration
+	prev := start
+	for {
+		if _, err = io.ReadFull(br, hdr[:]); err != nil {
+			return
+		}
+		l := binary.BigEndian.Uint32(hdr[1:])
+		switch hdr[0] {
+		case bulkFrameData:
+			var r int64
+			r, err = io.CopyN(io.Discard, br, int64(l))
+			n += r
+			if err != nil {
+				return
+			}
+		case bulkFrameSample:
+			if l > rawMaxTrailerLen {
+				err = fmt.Errorf("bulk sample too long: %d", l)
+				return
+			}
+			j := make([]byte, l)
+			if _, err = io.ReadFull(br, j); err != nil {
+				return
+			}
+			var ss bulkSample
+			if err = json.Unmarshal(j, &ss); err != nil {
+				return
+			}
+			if upload {
+				// bytes received by the server, and the client's TCP_INFO
+				var info *TCPInfo
+				if ti, e := getConnTCPInfo(c); e == nil {
+					info = &ti
+				}
+				emit(newBulkSample(f, ss.Time-prevT, ss.Bytes-prevN,
+					info))
+				prevT, prevN = ss.Time, ss.Bytes
+			} else {
+				// bytes received by the client, and the server's TCP_INFO
+				now := time.Now()
+				emit(newBulkSample(f, now.Sub(prev), n-prevN, ss.TCPInfo))
+				prev, prevN = now, n
+			}
+		default:
+			err = fmt.Errorf("unknown bulk frame type: %d", hdr[0])
+			return
+		}
+	}
+}
+
+// getConnTCPInfo returns the TCP_INFO for a TCP connection.
+func getConnTCPInfo(c *net.TCPConn) (info TCPInfo, err error) {
+	err = connControl(c, func(fd int) (err error) {
+		info, err = GetTCPInfo(fd)
+		return
+	})
+	return
+}
@@ -0,0 +1,122 @@
+package ccafct
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startBulkServer runs a BulkServer on a loopback listener until the test
+// ends, and returns its address.
+func startBulkServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runBulkServer(t, l)
+}
+
+// runBulkServer runs a BulkServer on the given listener until the test ends,
+// and returns its address.
+func runBulkServer(t *testing.T, l net.Listener) string {
+	t.Helper()
+	s := &BulkServer{Listener: l}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %s", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+func TestBulkAcceptRetry(t *testing.T) {
+	b := &Bulk{
+		Addr:     runBulkServer(t, newFailingListener(t, 3)),
+		Duration: 100 * time.Millisecond,
+		Interval: 50 * time.Millisecond,
+	}
+	var n int
+	if err := b.Run(context.Background(), func(s BulkSample) {
+		n++
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Error("no samples after the accept errors")
+	}
+}
+
+func TestBulkFlows(t *testing.T) {
+	addr := startBulkServer(t)
+	for _, dir := range []string{Download, Upload} {
+		b := &Bulk{
+			Addr:      addr,
+			Flows:     2,
+			Direction: dir,
+			Duration:  300 * time.Millisecond,
+			Interval:  50 * time.Millisecond,
+		}
+		count := make(map[int]int)
+		var bytes int64
+		if err := b.Run(context.Background(), func(s BulkSample) {
+			count[s.Flow]++
+			bytes += s.Bytes
+			if s.Interval <= 0 {
+				t.Errorf("%s: sample with interval %s", dir, s.Interval)
+			}
+			if s.Direction != dir {
+				t.Errorf("%s: sample with direction %s", dir, s.Direction)
+			}
+		}); err != nil {
+			t.Fatalf("%s: %s", dir, err)
+		}
+		// about six samples per flow are expected
+		for f := 0; f < b.Flows; f++ {
+			if count[f] < 3 {
+				t.Errorf("%s: flow %d has %d samples, want at least 3", dir, f,
+					count[f])
+			}
+		}
+		if bytes == 0 {
+			t.Errorf("%s: no bytes were transferred", dir)
+		}
+	}
+}
+
+func TestBulkServerRejectsRaw(t *testing.T) {
+	tst, err := NewTest(Params{
+		Addr:        startBulkServer(t),
+		Protocol:    ProtocolTCP,
+		ReplayTrace: writeTrace(t, "0,1000\n0.01,1000\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tst.Run(context.Background()); err == nil ||
+		!strings.Contains(err.Error(), "invalid raw magic") {
+		t.Errorf("Run error '%v', want invalid raw magic", err)
+	}
+}
+
+func TestBulkInvalid(t *testing.T) {
+	for _, b := range []Bulk{
+		{Flows: -1},
+		{Interval: -time.Second},
+		{Direction: "sideways"},
+		{ServerSockOpts: SockOpts{TOS: 0x100}},
+	} {
+		err := b.Run(context.Background(), func(BulkSample) {})
+		if err == nil {
+			t.Errorf("Run accepted %+v", b)
+		}
+	}
+}
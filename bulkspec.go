@@ -0,0 +1,173 @@
+package ccafct
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BulkGroup is a group of bulk flows with the same CCA and direction, started
+// at regular intervals.
+type BulkGroup struct {
+	// Count is the number of flows in the group.
+	Count int
+
+	// CCA is the congestion control algorithm.
+	CCA string
+
+	// Start is when the first flow starts, relative to the start of the run.
+	Start time.Duration `json:",omitempty"`
+
+	// Every is the time between the starts of consecutive flows.
+	Every time.Duration `json:",omitempty"`
+
+	// Duration is how long each flow runs. If zero, flows run until the end
+	// of the run.
+	Duration time.Duration `json:",omitempty"`
+
+	// Direction is the flow direction, either download or upload. If empty,
+	// the run's direction is used.
+	Direction string `json:",omitempty"`
+}
+
+// BulkSpec is a list of bulk flow groups, e.g. for a set of competing flows.
+type BulkSpec []BulkGroup
+
+// ParseBulkSpec parses a bulk spec of groups joined by +, e.g.
+// "cubic+4xbbr:every=2s". Each group is an optional count followed by x, the
+// CCA, and optional colon separated options:
+//
+//	start=duration  start the first flow after duration
+//	every=duration  start each subsequent flow duration apart
+//	dur=duration    run each flow for duration
+//	up              upload flows
+//	down            download flows
+func ParseBulkSpec(s string) (spec BulkSpec, err error) {
+	for _, gs := range strings.Split(s, "+") {
+		var g BulkGroup
+		if g, err = parseBulkGroup(strings.TrimSpace(gs)); err != nil {
+			return
+		}
+		spec = append(spec, g)
+	}
+	err = spec.Validate()
+	return
+}
+
+// parseBulkGroup parses one group of a bulk spec.
+func parseBulkGroup(s string) (g BulkGroup, err error) {
+	f := strings.Split(s, ":")
+	g.Count = 1
+	g.CCA = f[0]
+	if i := strings.Index(f[0], "x"); i > 0 {
+		if n, e := strconv.Atoi(f[0][:i]); e == nil {
+			g.Count = n
+			g.CCA = f[0][i+1:]
+		}
+	}
+	for _, o := range f[1:] {
+		kv := strings.SplitN(o, "=", 2)
+		var d *time.Duration
+		switch kv[0] {
+		case "start":
+			d = &g.Start
+		case "every":
+			d = &g.Every
+		case "dur":
+			d = &g.Duration
+		case "up":
+			g.Direction = Upload
+		case "down":
+			g.Direction = Download
+		default:
+			err = fmt.Errorf("unknown option '%s' in bulk group '%s'", o, s)
+			return
+		}
+		if (d != nil) != (len(kv) == 2) {
+			err = fmt.Errorf("invalid option '%s' in bulk group '%s'", o, s)
+			return
+		}
+		if d != nil {
+			if *d, err = time.ParseDuration(kv[1]); err != nil {
+				err = fmt.Errorf("invalid duration in bulk group '%s': %s",
+					s, err)
+				return
+			}
+		}
+	}
+	return
+}
+
+// Validate returns an error if the spec is invalid.
+func (s BulkSpec) Validate() error {
+	if len(s) == 0 {
+		return fmt.Errorf("empty bulk spec")
+	}
+	for _, g := range s {
+		if g.CCA == "" {
+			return fmt.Errorf("empty CCA in bulk spec")
+		}
+		if g.Count <= 0 {
+			return fmt.Errorf("bulk flow count must be positive for '%s'",
+				g.CCA)
+		}
+		if g.Start < 0 || g.Every < 0 || g.Duration < 0 {
+			return fmt.Errorf("negative duration in bulk group for '%s'",
+				g.CCA)
+		}
+		if g.Direction != "" && g.Direction != Download &&
+			g.Direction != Upload {
+			return fmt.Errorf("unknown direction: '%s'", g.Direction)
+		}
+	}
+	return nil
+}
+
+// Flows returns the total number of flows in the spec.
+func (s BulkSpec) Flows() (n int) {
+	for _, g := range s {
+		n += g.Count
+	}
+	return
+}
+
+// LastStart returns the start time of the last flow to start, relative to the
+// start of the run.
+func (s BulkSpec) LastStart() (t time.Duration) {
+	for _, g := range s {
+		if l := g.Start + time.Duration(g.Count-1)*g.Every; l > t {
+			t = l
+		}
+	}
+	return
+}
+
+// String returns the spec in the form parsed by ParseBulkSpec.
+func (s BulkSpec) String() string {
+	gs := make([]string, len(s))
+	for i, g := range s {
+		var b strings.Builder
+		if g.Count != 1 {
+			fmt.Fprintf(&b, "%dx", g.Count)
+		}
+		b.WriteString(g.CCA)
+		if g.Start != 0 {
+			fmt.Fprintf(&b, ":start=%s", g.Start)
+		}
+		if g.Every != 0 {
+			fmt.Fprintf(&b, ":every=%s", g.Every)
+		}
+		if g.Duration != 0 {
+			fmt.Fprintf(&b, ":dur=%s", g.Duration)
+		}
+		switch g.Direction {
+		case Upload:
+			b.WriteString(":up")
+		case Download:
+			b.WriteString(":down")
+		}
+		gs[i] = b.String()
+	}
+	return strings.Join(gs, "+")
+}
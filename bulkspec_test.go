@@ -0,0 +1,112 @@
+package ccafct
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBulkSpec(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		spec BulkSpec
+		str  string
+	}{
+		{"cubic", BulkSpec{{Count: 1, CCA: "cubic"}}, "cubic"},
+		{"4xbbr:every=2s", BulkSpec{
+			{Count: 4, CCA: "bbr", Every: 2 * time.Second},
+		}, "4xbbr:every=2s"},
+		{"cubic+2xbbr:start=10s:dur=30s:up", BulkSpec{
+			{Count: 1, CCA: "cubic"},
+			{Count: 2, CCA: "bbr", Start: 10 * time.Second,
+				Duration: 30 * time.Second, Direction: Upload},
+		}, "cubic+2xbbr:start=10s:dur=30s:up"},
+		{" prague:down:start=1.5s + 1xreno ", BulkSpec{
+			{Count: 1, CCA: "prague", Start: 1500 * time.Millisecond,
+				Direction: Download},
+			{Count: 1, CCA: "reno"},
+		}, "prague:start=1.5s:down+reno"},
+		{"bbrx", BulkSpec{{Count: 1, CCA: "bbrx"}}, "bbrx"},
+	} {
+		spec, err := ParseBulkSpec(c.in)
+		if err != nil {
+			t.Errorf("ParseBulkSpec(%q): %s", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(spec, c.spec) {
+			t.Errorf("ParseBulkSpec(%q) = %+v, want %+v", c.in, spec, c.spec)
+		}
+		s := spec.String()
+		if s != c.str {
+			t.Errorf("String() = %q, want %q", s, c.str)
+		}
+		rt, err := ParseBulkSpec(s)
+		if err != nil {
+			t.Errorf("ParseBulkSpec(%q): %s", s, err)
+		} else if !reflect.DeepEqual(rt, spec) {
+			t.Errorf("round trip of %q = %+v, want %+v", s, rt, spec)
+		}
+	}
+}
+
+func TestParseBulkSpecRejects(t *testing.T) {
+	for _, in := range []string{
+		"", "cubic+", "0xcubic", "cubic:fast", "cubic:start", "cubic:up=1",
+		"cubic:every=x", "cubic:start=-1s",
+	} {
+		if spec, err := ParseBulkSpec(in); err == nil {
+			t.Errorf("ParseBulkSpec(%q) = %+v, want error", in, spec)
+		}
+	}
+}
+
+func TestBulkSpecLastStart(t *testing.T) {
+	spec, err := ParseBulkSpec("cubic:start=5s+3xbbr:start=1s:every=3s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := spec.LastStart(); l != 7*time.Second {
+		t.Errorf("LastStart() = %s, want %s", l, 7*time.Second)
+	}
+}
+
+func TestBulkSpecStaggered(t *testing.T) {
+	spec, err := ParseBulkSpec("reno+cubic:start=200ms:dur=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Bulk{
+		Addr:     startBulkServer(t),
+		Spec:     spec,
+		Duration: 600 * time.Millisecond,
+		Interval: 50 * time.Millisecond,
+	}
+	first := make(map[string]time.Duration)
+	last := make(map[string]time.Duration)
+	start := time.Now()
+	if err = b.Run(context.Background(), func(s BulkSample) {
+		at := s.Time.Sub(start)
+		if _, ok := first[s.CCA]; !ok {
+			first[s.CCA] = at
+		}
+		last[s.CCA] = at
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 {
+		t.Fatalf("samples for %d CCAs, want 2", len(first))
+	}
+	if f := first["reno"]; f > 150*time.Millisecond {
+		t.Errorf("first reno sample at %s, want it from the start", f)
+	}
+	if f := first["cubic"]; f < 200*time.Millisecond {
+		t.Errorf("first cubic sample at %s, before its start", f)
+	}
+	if l := last["cubic"]; l > 500*time.Millisecond {
+		t.Errorf("last cubic sample at %s, after its duration", l)
+	}
+	if l := last["reno"]; l < 500*time.Millisecond {
+		t.Errorf("last reno sample at %s, want it until the end", l)
+	}
+}
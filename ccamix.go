@@ -0,0 +1,116 @@
+package ccafct
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/heistp/fct/pretty"
+	"golang.org/x/exp/rand"
+)
+
+// CCAWeight is one CCA in a CCAMix.
+type CCAWeight struct {
+	// CCA is the congestion control algorithm.
+	CCA string
+
+	// Weight is the relative weight of the CCA in the mix.
+	Weight float64
+}
+
+// CCAMix is a weighted mix of CCAs, from which the CCA for each flow in a
+// workload is chosen at random.
+type CCAMix []CCAWeight
+
+// ParseCCAMix parses a CCA mix of comma separated cca:weight pairs, e.g.
+// "cubic:70,bbr:30". The weights are relative, and need not sum to 100.
+func ParseCCAMix(s string) (m CCAMix, err error) {
+	for _, f := range strings.Split(s, ",") {
+		p := strings.Split(strings.TrimSpace(f), ":")
+		if len(p) != 2 {
+			err = fmt.Errorf("invalid CCA mix entry: '%s' (expected cca:weight)",
+				f)
+			return
+		}
+		var w float64
+		if w, err = strconv.ParseFloat(p[1], 64); err != nil {
+			err = fmt.Errorf("invalid weight in CCA mix entry: '%s'", f)
+			return
+		}
+		m = append(m, CCAWeight{p[0], w})
+	}
+	err = m.Validate()
+	return
+}
+
+// Validate returns an error if the mix is invalid.
+func (m CCAMix) Validate() error {
+	var total float64
+	seen := make(map[string]bool)
+	for _, w := range m {
+		if w.CCA == "" {
+			return fmt.Errorf("empty CCA in CCA mix")
+		}
+		if seen[w.CCA] {
+			return fmt.Errorf("duplicate CCA in CCA mix: '%s'", w.CCA)
+		}
+		seen[w.CCA] = true
+		if w.Weight < 0 || math.IsNaN(w.Weight) || math.IsInf(w.Weight, 0) {
+			return fmt.Errorf("invalid weight for CCA '%s' in CCA mix: %v",
+				w.CCA, w.Weight)
+		}
+		total += w.Weight
+	}
+	if total <= 0 {
+		return fmt.Errorf("CCA mix has no positive weights")
+	}
+	return nil
+}
+
+// String returns the mix with each CCA's share as a percentage.
+func (m CCAMix) String() string {
+	var total float64
+	for _, w := range m {
+		total += w.Weight
+	}
+	s := make([]string, len(m))
+	for i, w := range m {
+		s[i] = fmt.Sprintf("%s %s%%", w.CCA,
+			pretty.Float64(w.Weight/total*100, 1))
+	}
+	return strings.Join(s, ", ")
+}
+
+// CCAs returns the CCAs in the mix, sorted by name.
+func (m CCAMix) CCAs() (ccas []string) {
+	ccas = make([]string, len(m))
+	for i, w := range m {
+		ccas[i] = w.CCA
+	}
+	sort.Strings(ccas)
+	return
+}
+
+// picker returns a function that chooses CCAs from the mix at random, using
+// the given source.
+func (m CCAMix) picker(src rand.Source) func() string {
+	cum := make([]float64, len(m))
+	var total float64
+	for i, w := range m {
+		total += w.Weight
+		cum[i] = total
+	}
+	rnd := rand.New(src)
+	return func() string {
+		x := rnd.Float64() * total
+		i := sort.Search(len(cum), func(i int) bool {
+			return cum[i] > x
+		})
+		if i == len(cum) {
+			i--
+		}
+		return m[i].CCA
+	}
+}
@@ -0,0 +1,99 @@
+package ccafct
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/rand"
+)
+
+func TestParseCCAMix(t *testing.T) {
+	for _, c := range []struct {
+		in  string
+		mix CCAMix
+	}{
+		{"cubic:70,bbr:30", CCAMix{{"cubic", 70}, {"bbr", 30}}},
+		{" cubic:1 , prague:0.5", CCAMix{{"cubic", 1}, {"prague", 0.5}}},
+		{"cubic:1,bbr:0", CCAMix{{"cubic", 1}, {"bbr", 0}}},
+	} {
+		m, err := ParseCCAMix(c.in)
+		if err != nil {
+			t.Errorf("ParseCCAMix(%q): %s", c.in, err)
+		} else if !reflect.DeepEqual(m, c.mix) {
+			t.Errorf("ParseCCAMix(%q) = %v, want %v", c.in, m, c.mix)
+		}
+	}
+	for _, in := range []string{
+		"cubic", "cubic:1:2", "cubic:x", ":1", "cubic:1,cubic:2",
+		"cubic:-1,bbr:2", "cubic:NaN", "cubic:Inf", "cubic:0,bbr:0",
+	} {
+		if m, err := ParseCCAMix(in); err == nil {
+			t.Errorf("ParseCCAMix(%q) = %v, want error", in, m)
+		}
+	}
+}
+
+func TestCCAMixString(t *testing.T) {
+	m := CCAMix{{"cubic", 2}, {"bbr", 1}, {"reno", 1}}
+	if s, want := m.String(), "cubic 50%, bbr 25%, reno 25%"; s != want {
+		t.Errorf("String() = '%s', want '%s'", s, want)
+	}
+	want := []string{"bbr", "cubic", "reno"}
+	if c := m.CCAs(); !reflect.DeepEqual(c, want) {
+		t.Errorf("CCAs() = %v, want %v", c, want)
+	}
+}
+
+func TestCCAMixPicker(t *testing.T) {
+	m := CCAMix{{"cubic", 70}, {"bbr", 30}, {"reno", 0}}
+	pick := m.picker(rand.NewSource(1))
+	const n = 100000
+	count := make(map[string]int)
+	for i := 0; i < n; i++ {
+		count[pick()]++
+	}
+	if count["reno"] > 0 {
+		t.Errorf("picked reno %d times with zero weight", count["reno"])
+	}
+	if f := float64(count["cubic"]) / n; math.Abs(f-0.7) > 0.01 {
+		t.Errorf("picked cubic for %.3f of flows, want 0.7", f)
+	}
+}
+
+func TestCCAMixWorkload(t *testing.T) {
+	r := newLengthRecorder(t)
+	tst, err := NewTest(Params{
+		Addr:        r.addr(),
+		CCAMix:      CCAMix{{"cubic", 1}, {"reno", 1}},
+		ReplayTrace: writeTrace(t, "0,1000,bbr\n0.01,1000\n0.02,1000\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tst.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bbr int
+	for _, f := range data.Flow {
+		switch f.CCA {
+		case "bbr":
+			bbr++
+		case "cubic", "reno":
+		default:
+			t.Errorf("flow CCA '%s' isn't from the trace or the mix", f.CCA)
+		}
+	}
+	if bbr != 1 {
+		t.Errorf("%d bbr flows, want the 1 from the trace", bbr)
+	}
+	stats, err := AnalyzeCCA(&data, DefaultStatSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := data.CCAs(); len(stats) != len(c) {
+		t.Errorf("stats for %d CCAs, want %d", len(stats), len(c))
+	}
+}
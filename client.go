@@ -2,21 +2,27 @@ package ccafct
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/heistp/fct/bitrate"
 	"github.com/heistp/fct/pretty"
 	"github.com/heistp/fct/unit"
+	"golang.org/x/exp/rand"
 	"gonum.org/v1/gonum/stat/distuv"
 )
 
@@ -30,16 +36,50 @@ func (w *countWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// zeroReader reads zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (n int, err error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// zeros returns a Reader for n zero bytes.
+func zeros(n int) io.Reader {
+	return io.LimitReader(zeroReader{}, int64(n))
+}
+
 var DefaultAddr = "localhost"
 
 var DefaultCCA = "cubic"
 
+var DefaultProtocol = ProtocolHTTP
+
+var DefaultDirection = Download
+
 var DefaultDuration = 10 * time.Second
 
 var DefaultMeanArrival = 200 * time.Millisecond
 
 var DefaultArrivalExpRate = 1.0
 
+var DefaultLoop = OpenLoop
+
+var DefaultConcurrency = 8
+
+// Workload loop modes.
+const (
+	// OpenLoop starts flows according to the arrival process, regardless of
+	// how many are outstanding.
+	OpenLoop = "open"
+
+	// ClosedLoop runs a fixed number of request slots, each of which starts a
+	// new flow when its previous one completes.
+	ClosedLoop = "closed"
+)
+
 var DefaultLenP5 = 64 * unit.Kilobyte
 
 var DefaultLenP95 = 2 * unit.Megabyte
@@ -52,22 +92,99 @@ type Params struct {
 	// CCA is the congestion control algorithm.
 	CCA string
 
+	// CCAMix, if set, is a weighted mix of CCAs from which each flow's CCA is
+	// chosen, instead of using CCA.
+	CCAMix CCAMix
+
+	// Protocol is the FCT protocol, either http (the default) or tcp. If Addr
+	// has no port, the default port for the protocol is used.
+	Protocol string
+
+	// Direction is the flow direction, either download (the default) or
+	// upload.
+	Direction string
+
+	// ServerSockOpts are the socket options the server sets on its sockets,
+	// which are sent with each request. The CCA field is ignored, and the
+	// flow's CCA is used instead.
+	ServerSockOpts SockOpts
+
+	// ClientSockOpts are the socket options set on the client's sockets. If
+	// ClientSockOpts.CCA is empty, the flow's CCA is used, so that the
+	// client's data and acknowledgements use the same algorithm as the
+	// server.
+	ClientSockOpts SockOpts
+
 	// Duration is the test duration.
 	Duration time.Duration
 
-	// MeanArrival is the mean arrival time between requests.
+	// MeanArrival is the mean arrival time between requests. It is calculated
+	// by NewTest if Load is set.
 	MeanArrival time.Duration
 
+	// Bottleneck is the bottleneck link rate. If set, the offered load is
+	// calculated relative to it.
+	Bottleneck bitrate.Bitrate
+
+	// Load, if set, is the offered load as a fraction of Bottleneck, from
+	// which MeanArrival is calculated using the mean flow length.
+	Load float64
+
+	// AllowOverload permits an offered load greater than Bottleneck, which
+	// is otherwise an error. A warning is logged instead.
+	AllowOverload bool
+
 	// ArrivalExpRate is the rate parameter for the exponential arrival time
-	// distribution.
+	// distribution of the poisson arrival model.
 	ArrivalExpRate float64
 
+	// Arrival contains the arrival process parameters.
+	Arrival ArrivalParams
+
+	// LenModel is the flow length model (default lognormal).
+	LenModel string
+
+	// LenCDFFile is the name of a file containing the flow length CDF, in the
+	// form read by ReadCDF, for the cdf flow length model. It is read by
+	// NewTest.
+	LenCDFFile string
+
 	// LenP5 is the 5th percentile of the lognormal flow length distribution.
 	LenP5 unit.Bytes
 
 	// LenP95 is the 95th percentile of the lognormal flow length distribution.
 	LenP95 unit.Bytes
 
+	// Loop is the workload loop mode, either open (the default) or closed.
+	Loop string
+
+	// Concurrency is the number of request slots in closed-loop mode.
+	Concurrency int
+
+	// ThinkTime is the mean of the exponentially distributed time that each
+	// request slot waits between flows in closed-loop mode. If zero, there is
+	// no wait.
+	ThinkTime time.Duration
+
+	// MaxOutstanding, if set, is the maximum number of outstanding flows in
+	// open-loop mode. Flows that arrive when the maximum is reached are
+	// dropped, and counted in Data.Dropped.
+	MaxOutstanding int
+
+	// Seed seeds the arrival and flow length distributions and the CCA mix,
+	// so that tests with the same Seed produce the same workload. If zero, a
+	// seed is chosen from the current time.
+	Seed uint64
+
+	// RecordTrace, if set, is the name of a file to write the test's workload
+	// schedule to when it's run, in the form read by ReadTrace.
+	RecordTrace string
+
+	// ReplayTrace, if set, is the name of a trace file, in the form read by
+	// ReadTrace, to replay instead of sampling the arrival and flow length
+	// distributions. It is read by NewTest.
+	ReplayTrace string
+
 	// DisableGC disables the garbage collector during the test if set.
 	DisableGC bool
 }
@@ -79,6 +196,12 @@ func (p *Params) init() {
 	if p.CCA == "" {
 		p.CCA = DefaultCCA
 	}
+	if p.Protocol == "" {
+		p.Protocol = DefaultProtocol
+	}
+	if p.Direction == "" {
+		p.Direction = DefaultDirection
+	}
 	if p.Duration == 0 {
 		p.Duration = DefaultDuration
 	}
@@ -88,12 +211,36 @@ func (p *Params) init() {
 	if p.ArrivalExpRate == 0 {
 		p.ArrivalExpRate = DefaultArrivalExpRate
 	}
+	p.Arrival.init()
+	if p.LenModel == "" {
+		p.LenModel = DefaultLenModel
+		if p.LenCDFFile != "" {
+			p.LenModel = LenCDF
+		}
+	}
 	if p.LenP5 == 0 {
 		p.LenP5 = DefaultLenP5
 	}
 	if p.LenP95 == 0 {
 		p.LenP95 = DefaultLenP95
 	}
+	if p.Loop == "" {
+		p.Loop = DefaultLoop
+	}
+	if p.Concurrency == 0 {
+		p.Concurrency = DefaultConcurrency
+	}
+	if p.Seed == 0 {
+		p.Seed = NewSeed()
+	}
+}
+
+// NewSeed returns a new non-zero seed based on the current time.
+func NewSeed() (seed uint64) {
+	for seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+	return
 }
 
 // Test contains the test parameters and related test configuration.
@@ -103,41 +250,104 @@ type Test struct {
 	// URL is the server URL
 	URL string
 
-	// Flows is the number of flows that will run.
+	// Flows is the number of flows that will run, or zero in closed-loop
+	// mode.
 	Flows int
 
-	// ArrivalDist is the flow arrival distribution.
-	ArrivalDist distuv.Exponential
-
-	// LenDist is the flow length distribution.
+	// LenDist is the flow length distribution for the lognormal model. Its
+	// source is seeded from Seed when the test is run.
 	LenDist distuv.LogNormal
 
+	// LenCDF is the flow length CDF for empirical models.
+	LenCDF CDF
+
 	// MeanFlowLen is the mean flow length.
 	MeanFlowLen int
 
-	// Bandwidth is the estimated bandwidth.
+	// Bandwidth is the estimated bandwidth, or zero in closed-loop mode.
 	Bandwidth bitrate.Bitrate
 
+	// Utilization is the offered load as a fraction of Bottleneck, or zero if
+	// Bottleneck is not set.
+	Utilization float64
+
+	// Trace is the replayed workload schedule, if ReplayTrace is set.
+	Trace []Arrival
+
+	// lastID is the last flow ID. Its upper 32 bits are chosen at random when
+	// the test is run, so flows from different runs may share a server log.
+	lastID uint64
+
 	sync.WaitGroup
 }
 
 // NewTest returns a new test given test parameters.
-func NewTest(p Params) (t Test) {
+func NewTest(p Params) (t Test, err error) {
 	t.Params = p
 	t.Params.init()
 
 	// server URL
+	var port int
+	switch t.Protocol {
+	case ProtocolHTTP:
+		port = DefaultPort
+	case ProtocolTCP:
+		port = DefaultRawPort
+	default:
+		err = fmt.Errorf("unknown protocol: '%s'", t.Protocol)
+		return
+	}
 	f := strings.Split(t.Addr, ":")
 	if len(f) == 1 {
-		t.Addr = fmt.Sprintf("%s:%d", t.Addr, DefaultPort)
+		t.Addr = fmt.Sprintf("%s:%d", t.Addr, port)
+	}
+	if t.Protocol == ProtocolHTTP {
+		t.URL = fmt.Sprintf("http://%s%s", t.Addr, FCTPath)
 	}
-	t.URL = fmt.Sprintf("http://%s%s", t.Addr, FCTPath)
 
-	// number of flows
-	t.Flows = int(t.Duration / t.MeanArrival)
+	// socket options
+	if err = t.ServerSockOpts.Validate(); err != nil {
+		return
+	}
+	if err = t.ClientSockOpts.Validate(); err != nil {
+		return
+	}
+
+	// CCA mix
+	if len(t.CCAMix) > 0 {
+		if err = t.CCAMix.Validate(); err != nil {
+			return
+		}
+	}
+
+	// direction
+	if t.Direction != Download && t.Direction != Upload {
+		err = fmt.Errorf("unknown direction: '%s'", t.Direction)
+		return
+	}
 
-	// arrival distribution
-	t.ArrivalDist = distuv.Exponential{Rate: t.ArrivalExpRate}
+	// loop mode
+	switch t.Loop {
+	case OpenLoop:
+		if t.MaxOutstanding < 0 {
+			err = fmt.Errorf("invalid max outstanding: %d", t.MaxOutstanding)
+			return
+		}
+	case ClosedLoop:
+		if t.Concurrency < 1 || t.ThinkTime < 0 {
+			err = fmt.Errorf("invalid concurrency or think time: %d/%s",
+				t.Concurrency, t.ThinkTime)
+			return
+		}
+	default:
+		err = fmt.Errorf("unknown loop mode: '%s'", t.Loop)
+		return
+	}
+
+	// arrival process
+	if err = t.Arrival.Validate(); err != nil {
+		return
+	}
 
 	// flow length distribution
 	log5 := math.Log(float64(t.LenP5))
@@ -145,30 +355,246 @@ func NewTest(p Params) (t Test) {
 	mu := (log5 + log95) / 2
 	sigma := (log95 - log5) / (2 * 1.645)
 	t.LenDist = distuv.LogNormal{Mu: mu, Sigma: sigma}
+	if t.LenCDF, err = LenModelCDF(t.LenModel, t.LenCDFFile); err != nil {
+		return
+	}
 
-	// calculate mean flow length and bandwidth
-	rps := float64(1 * time.Second / t.MeanArrival)
-	mfl := math.Exp(mu + 0.5*math.Pow(sigma, 2))
+	// closed-loop tests have no arrivals, so no offered load
+	mfl := t.FlowSizeDist(nil).Mean()
 	t.MeanFlowLen = int(mfl)
+	if t.Loop == ClosedLoop {
+		if t.Load != 0 {
+			err = fmt.Errorf("offered load may not be used in closed-loop " +
+				"mode")
+			return
+		}
+		if t.ReplayTrace != "" {
+			if t.Trace, err = ReadTraceFile(t.ReplayTrace); err != nil {
+				return
+			}
+			t.setTraceLength()
+		}
+		return
+	}
+
+	// calculate mean arrival time for the offered load
+	if t.Load < 0 {
+		err = fmt.Errorf("invalid offered load: %f", t.Load)
+		return
+	}
+	if t.Load > 0 {
+		if t.Bottleneck <= 0 {
+			err = fmt.Errorf("offered load requires a bottleneck rate")
+			return
+		}
+		sec := mfl * 8 / (t.Load * float64(t.Bottleneck))
+		t.MeanArrival = time.Duration(sec * float64(time.Second))
+	}
+	if t.MeanArrival <= 0 {
+		err = fmt.Errorf("mean arrival time %s is not positive, the offered "+
+			"load may be too high for the flow lengths", t.MeanArrival)
+		return
+	}
+
+	// number of flows
+	t.Flows = int(t.Duration / t.MeanArrival)
+
+	// calculate bandwidth
+	rps := time.Second.Seconds() / t.MeanArrival.Seconds()
 	t.Bandwidth = bitrate.Bitrate(rps * mfl * 8)
 
+	// replay trace
+	if t.ReplayTrace != "" {
+		if t.Trace, err = ReadTraceFile(t.ReplayTrace); err != nil {
+			return
+		}
+		if err = t.setTraceParams(); err != nil {
+			return
+		}
+	}
+
+	// check utilization
+	if t.Bottleneck > 0 {
+		t.Utilization = float64(t.Bandwidth) / float64(t.Bottleneck)
+		if t.Utilization > 1 {
+			msg := fmt.Sprintf("offered load %s exceeds bottleneck %s (%s%%)",
+				t.Bandwidth, t.Bottleneck,
+				pretty.Float64(t.Utilization*100, 1))
+			if !t.AllowOverload {
+				err = errors.New(msg)
+				return
+			}
+			log.Printf("warning: %s", msg)
+		}
+	}
+
+	return
+}
+
+// FlowSizeDist returns the flow length distribution, using the given source of
+// randomness. If src is nil, only the Mean and Quantile methods may be used.
+func (t *Test) FlowSizeDist(src rand.Source) FlowSizeDistribution {
+	if t.LenCDF != nil {
+		var rnd *rand.Rand
+		if src != nil {
+			rnd = rand.New(src)
+		}
+		return empiricalSize{t.LenCDF, rnd}
+	}
+	d := t.LenDist
+	d.Src = src
+	return logNormalSize{d}
+}
+
+// setTraceParams sets the flow count, duration, mean arrival, mean flow length
+// and bandwidth from the replayed trace. It returns an error if the trace spans
+// no time, as its rate is then unknown.
+func (t *Test) setTraceParams() error {
+	if t.Trace[len(t.Trace)-1].Offset <= 0 {
+		return fmt.Errorf("%s: trace spans no time, so its rate is unknown",
+			t.ReplayTrace)
+	}
+	total := t.setTraceLength()
+	t.Flows = len(t.Trace)
+	t.Duration = t.Trace[len(t.Trace)-1].Offset
+	t.MeanArrival = t.Duration / time.Duration(t.Flows-1)
+	t.Bandwidth = bitrate.Bitrate(float64(total) * 8 / t.Duration.Seconds())
+	return nil
+}
+
+// setTraceLength sets the mean flow length from the replayed trace, and
+// returns the total length of its flows.
+func (t *Test) setTraceLength() (total unit.Bytes) {
+	for _, a := range t.Trace {
+		total += a.Length
+	}
+	t.MeanFlowLen = int(total) / len(t.Trace)
 	return
 }
 
+// schedule returns the workload schedule, either from the replayed trace, or by
+// sampling the arrival process and flow length distribution.
+func (t *Test) schedule() (sched []Arrival, err error) {
+	if t.Trace != nil {
+		sched = append([]Arrival(nil), t.Trace...)
+		t.setCCAs(sched)
+		return
+	}
+
+	// seed distributions, with separate sources so that flow lengths don't
+	// depend on the number of arrival samples taken
+	var ap ArrivalProcess
+	if ap, err = NewArrivalProcess(t.Params,
+		rand.NewSource(t.Seed)); err != nil {
+		return
+	}
+	lenDist := t.FlowSizeDist(rand.NewSource(t.Seed + 1))
+
+	sched = make([]Arrival, t.Flows)
+	var off time.Duration
+	for i := range sched {
+		if i > 0 {
+			off += ap.Next()
+		}
+		sched[i] = Arrival{off, lenDist.Rand(), ""}
+	}
+	t.setCCAs(sched)
+
+	return
+}
+
+// ccaPicker returns a function that chooses flow CCAs from CCAMix, or nil if
+// CCAMix isn't set. Its source is seeded from Seed-1, so the CCAs don't depend
+// on the arrival and flow length samples.
+func (t *Test) ccaPicker() func() string {
+	if len(t.CCAMix) == 0 {
+		return nil
+	}
+	return t.CCAMix.picker(rand.NewSource(t.Seed - 1))
+}
+
+// setCCAs sets the CCA for arrivals without one from CCAMix, if set.
+func (t *Test) setCCAs(sched []Arrival) {
+	pick := t.ccaPicker()
+	if pick == nil {
+		return
+	}
+	for i := range sched {
+		if sched[i].CCA == "" {
+			sched[i].CCA = pick()
+		}
+	}
+}
+
+// flowCCA returns the CCA for an arrival.
+func (t *Test) flowCCA(a Arrival) string {
+	if a.CCA != "" {
+		return a.CCA
+	}
+	return t.CCA
+}
+
 // emitTest emits the test parameters.
-func (t Test) Emit(w io.Writer) {
+func (t *Test) Emit(w io.Writer) {
 	// log some things
 	tw := pretty.NewTableWriter(w)
 	tw.Printf("Server URL:\t%s", t.Addr)
-	tw.Printf("CCA:\t%s", t.CCA)
+	tw.Printf("Protocol:\t%s", t.Protocol)
+	tw.Printf("Direction:\t%s", t.Direction)
+	if len(t.CCAMix) > 0 {
+		tw.Printf("CCA mix:\t%s", t.CCAMix)
+	} else {
+		tw.Printf("CCA:\t%s", t.CCA)
+	}
+	so := t.ServerSockOpts
+	so.CCA = ""
+	if so != (SockOpts{}) {
+		tw.Printf("Server socket options:\t%s", so)
+	}
+	if o := t.ClientSockOpts; o != (SockOpts{}) {
+		tw.Printf("Client socket options:\t%s", o)
+	}
 	tw.Printf("Duration:\t%s", t.Duration)
-	tw.Printf("Flows:\t%d", t.Flows)
-	tw.Printf("Mean arrival time:\t%s", t.MeanArrival)
-	tw.Printf("Est. bandwidth:\t%s", t.Bandwidth)
-	tw.Printf("Flow lengths:\t")
-	tw.Printf("|- P5:\t%d", t.LenP5)
-	tw.Printf("|- Mean:\t%d", t.MeanFlowLen)
-	tw.Printf("|- P95:\t%d", t.LenP95)
+	tw.Printf("Loop:\t%s", t.Loop)
+	if t.Loop == ClosedLoop {
+		tw.Printf("|- Concurrency:\t%d", t.Concurrency)
+		tw.Printf("|- Think time:\t%s", t.ThinkTime)
+	} else if t.MaxOutstanding > 0 {
+		tw.Printf("|- Max outstanding:\t%d", t.MaxOutstanding)
+	}
+	if t.Loop != ClosedLoop {
+		tw.Printf("Flows:\t%d", t.Flows)
+		tw.Printf("Mean arrival time:\t%s", t.MeanArrival)
+		if t.ReplayTrace == "" {
+			t.Arrival.Emit(tw)
+		}
+		tw.Printf("Est. bandwidth:\t%s", t.Bandwidth)
+		if t.Bottleneck > 0 {
+			tw.Printf("Offered load:\t%s%% of %s",
+				pretty.Float64(t.Utilization*100, 1), t.Bottleneck)
+		}
+	}
+	if t.ReplayTrace != "" {
+		tw.Printf("Replay trace:\t%s", t.ReplayTrace)
+	} else {
+		tw.Printf("Seed:\t%d", t.Seed)
+	}
+	if t.RecordTrace != "" {
+		tw.Printf("Record trace:\t%s", t.RecordTrace)
+	}
+	if t.ReplayTrace != "" {
+		tw.Printf("Flow lengths:\t")
+		tw.Printf("|- Mean:\t%d", t.MeanFlowLen)
+	} else {
+		d := t.FlowSizeDist(nil)
+		tw.Printf("Flow lengths:\t%s", t.LenModel)
+		if t.LenModel == LenCDF {
+			tw.Printf("|- CDF file:\t%s", t.LenCDFFile)
+		}
+		tw.Printf("|- P5:\t%d", int64(math.Round(d.Quantile(0.05))))
+		tw.Printf("|- Mean:\t%d", t.MeanFlowLen)
+		tw.Printf("|- P95:\t%d", int64(math.Round(d.Quantile(0.95))))
+	}
 	tw.Flush()
 }
 
@@ -179,19 +605,53 @@ func (t *Test) Run(ctx context.Context) (data Data, err error) {
 		debug.SetGCPercent(-1)
 	}
 
+	t.lastID = uint64(rand.New(rand.NewSource(NewSeed())).Uint32()) << 32
+	data = newData()
+	switch t.Loop {
+	case ClosedLoop:
+		err = t.runClosed(ctx, &data)
+	default:
+		err = t.runOpen(ctx, &data)
+	}
+	data.End = time.Now()
+
+	if t.DisableGC {
+		debug.SetGCPercent(100)
+		runtime.GC()
+	}
+
+	return
+}
+
+// runOpen runs an open-loop test, where flows start according to the schedule,
+// regardless of how many are outstanding.
+func (t *Test) runOpen(ctx context.Context, data *Data) (err error) {
+	var sched []Arrival
+	if sched, err = t.schedule(); err != nil {
+		return
+	}
+	if t.RecordTrace != "" {
+		if err = WriteTraceFile(t.RecordTrace, sched); err != nil {
+			return
+		}
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var outstanding chan struct{}
+	if t.MaxOutstanding > 0 {
+		outstanding = make(chan struct{}, t.MaxOutstanding)
+	}
 
-	data = newData()
 	data.Start = time.Now()
 	// the below could be more memory efficient for large flow counts, but we
 	// don't want to risk that goroutines can't exit on error
-	errCh := make(chan error, t.Flows)
+	errCh := make(chan error, len(sched))
 
 loop:
-	for i := 0; i < t.Flows; i++ {
-		if i > 0 {
-			waitNs := t.ArrivalDist.Rand() * float64(t.MeanArrival)
-			wait := time.Duration(waitNs) * time.Nanosecond
+	for _, a := range sched {
+		if wait := time.Until(data.Start.Add(a.Offset)); wait > 0 {
 			select {
 			case <-ctx.Done():
 				log.Printf("client context: '%s'", ctx.Err())
@@ -203,49 +663,219 @@ loop:
 			}
 		}
 
-		reqLen := int(t.LenDist.Rand())
+		if outstanding != nil {
+			select {
+			case outstanding <- struct{}{}:
+			default:
+				data.Dropped++
+				continue
+			}
+		}
+
+		reqLen, cca := int(a.Length), t.flowCCA(a)
 		t.Add(1)
-		go func(reqLen int, errCh chan error) {
+		go func(reqLen int, cca string, errCh chan error) {
+			defer t.Done()
+			if outstanding != nil {
+				defer func() {
+					<-outstanding
+				}()
+			}
 			var flow Flow
 			var rerr error
-			if flow, rerr = t.doRequest(ctx, reqLen); rerr != nil {
+			if flow, rerr = t.doFlow(ctx, reqLen, cca); rerr != nil {
 				errCh <- rerr
 				return
 			}
 			data.AddFlow(flow)
-		}(reqLen, errCh)
+		}(reqLen, cca, errCh)
 	}
 
 	t.Wait()
 
-	data.End = time.Now()
+	if err == nil {
+		select {
+		case err = <-errCh:
+		default:
+		}
+	}
 
-	if t.DisableGC {
-		debug.SetGCPercent(100)
-		runtime.GC()
+	return
+}
+
+// runClosed runs a closed-loop test, where each of Concurrency request slots
+// starts a new flow when its previous one completes, after an optional think
+// time, until Duration has elapsed.
+func (t *Test) runClosed(ctx context.Context, data *Data) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// flow lengths and CCAs come from the replayed trace in order, or from
+	// the flow length distribution and CCA mix
+	var mtx sync.Mutex
+	var lenDist FlowSizeDistribution
+	if t.Trace == nil {
+		lenDist = t.FlowSizeDist(rand.NewSource(t.Seed + 1))
+	}
+	pick := t.ccaPicker()
+	var sched []Arrival
+	next := func() (a Arrival, ok bool) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if t.Trace != nil {
+			if len(sched) >= len(t.Trace) {
+				return
+			}
+			a = t.Trace[len(sched)]
+		} else {
+			a.Length = lenDist.Rand()
+		}
+		if a.CCA == "" && pick != nil {
+			a.CCA = pick()
+		}
+		a.Offset = time.Since(data.Start)
+		sched = append(sched, a)
+		ok = true
+		return
+	}
+
+	data.Start = time.Now()
+	end := data.Start.Add(t.Duration)
+	errCh := make(chan error, t.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < t.Concurrency; i++ {
+		think := distuv.Exponential{
+			Rate: 1,
+			Src:  rand.NewSource(t.Seed + 2 + uint64(i)),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(end) {
+				a, ok := next()
+				if !ok {
+					return
+				}
+				flow, rerr := t.doFlow(ctx, int(a.Length), t.flowCCA(a))
+				if rerr != nil {
+					errCh <- rerr
+					cancel()
+					return
+				}
+				data.AddFlow(flow)
+				if t.ThinkTime > 0 {
+					w := time.Duration(think.Rand() * float64(t.ThinkTime))
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(w):
+					}
+				}
+			}
+		}()
 	}
+	wg.Wait()
+
+	select {
+	case err = <-errCh:
+		return
+	default:
+	}
+	if t.RecordTrace != "" {
+		err = WriteTraceFile(t.RecordTrace, sched)
+	}
+
+	return
+}
 
+// doFlow runs one flow with the given CCA, using the test's protocol.
+func (t *Test) doFlow(ctx context.Context, reqLen int, cca string) (
+	flow Flow, err error) {
+	id := atomic.AddUint64(&t.lastID, 1)
+	if t.Protocol == ProtocolTCP {
+		flow, err = t.doRawRequest(ctx, id, reqLen, cca)
+	} else {
+		flow, err = t.doRequest(ctx, id, reqLen, cca)
+	}
+	flow.ID = id
+	flow.CCA = cca
 	return
 }
 
-func (t *Test) doRequest(ctx context.Context, reqLen int) (flow Flow, err error) {
-	defer func() {
-		t.Done()
-	}()
+// dialer returns the Dialer for a flow's connections, which sets
+// ClientSockOpts on the client's sockets, with the given CCA if
+// ClientSockOpts.CCA is empty.
+func (t *Test) dialer(cca string) *net.Dialer {
+	o := t.ClientSockOpts
+	if o.CCA == "" {
+		o.CCA = cca
+	}
+	return &net.Dialer{Control: o.Control}
+}
 
-	client := &http.Client{}
+// clientTCPInfo returns the TCP_INFO for a client connection, or nil if it's
+// unavailable.
+func clientTCPInfo(c net.Conn) *TCPInfo {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	var info TCPInfo
+	if err := connControl(tc, func(fd int) (err error) {
+		info, err = GetTCPInfo(fd)
+		return
+	}); err != nil {
+		return nil
+	}
+	return &info
+}
+
+func (t *Test) doRequest(ctx context.Context, id uint64, reqLen int,
+	cca string) (flow Flow, err error) {
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: t.dialer(cca).DialContext},
+	}
 	defer client.CloseIdleConnections()
 
+	flow.Upload = t.Direction == Upload
+
 	var req *http.Request
-	if req, err = http.NewRequest("GET", t.URL, nil); err != nil {
+	if flow.Upload {
+		if req, err = http.NewRequest("POST", t.URL,
+			zeros(reqLen)); err != nil {
+			return
+		}
+	} else if req, err = http.NewRequest("GET", t.URL, nil); err != nil {
 		return
 	}
 	req.Header.Add(FlowLengthHeader, strconv.Itoa(reqLen))
-	req = req.WithContext(ctx)
-
-	if t.CCA != "" {
-		req.Header.Add(CCAHeader, t.CCA)
+	req.Header.Add(FlowIDHeader, strconv.FormatUint(id, 10))
+	var conn net.Conn
+	trace := &httptrace.ClientTrace{
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			flow.DNSDone = time.Now()
+		},
+		ConnectStart: func(network, addr string) {
+			flow.ConnectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			flow.Connected = time.Now()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			flow.WroteRequest = time.Now()
+		},
+		GotConn: func(i httptrace.GotConnInfo) {
+			conn = i.Conn
+		},
+		GotFirstResponseByte: func() {
+			flow.FirstByte = time.Now()
+		},
 	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	o := t.ServerSockOpts
+	o.CCA = cca
+	o.setHeader(req.Header)
 
 	flow.Start = time.Now()
 
@@ -253,6 +883,7 @@ func (t *Test) doRequest(ctx context.Context, reqLen int) (flow Flow, err error)
 	if resp, err = client.Do(req); err != nil {
 		return
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		err = fmt.Errorf("client received: %s (%d)",
@@ -260,13 +891,30 @@ func (t *Test) doRequest(ctx context.Context, reqLen int) (flow Flow, err error)
 		return
 	}
 
-	cw := new(countWriter)
-	if err = resp.Write(cw); err != nil {
-		return
+	if flow.Upload {
+		flow.End = time.Now()
+		flow.Length = unit.Bytes(reqLen)
+		flow.ClientTCPInfo = clientTCPInfo(conn)
+		if _, err = io.Copy(io.Discard, resp.Body); err != nil {
+			return
+		}
+	} else {
+		// count only the body, as the trailer is read after it
+		cw := new(countWriter)
+		if _, err = io.Copy(cw, resp.Body); err != nil {
+			return
+		}
+		flow.End = time.Now()
+		flow.Length = cw.Bytes
 	}
 
-	flow.End = time.Now()
-	flow.Length = cw.Bytes
+	if sf := resp.Trailer.Get(ServerFlowHeader); sf != "" {
+		s := new(ServerFlow)
+		if err = json.Unmarshal([]byte(sf), s); err != nil {
+			return
+		}
+		flow.Server = s
+	}
 
 	return
 }
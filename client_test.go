@@ -0,0 +1,329 @@
+package ccafct
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heistp/fct/bitrate"
+)
+
+// lengthRecorder is an HTTP server that records the requested flow lengths,
+// and responds with an empty body.
+type lengthRecorder struct {
+	*httptest.Server
+	mtx     sync.Mutex
+	lengths []int
+}
+
+// newLengthRecorder returns a started lengthRecorder, which is closed when the
+// test ends.
+func newLengthRecorder(t *testing.T) *lengthRecorder {
+	r := &lengthRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			l, err := strconv.Atoi(req.Header.Get(FlowLengthHeader))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.mtx.Lock()
+			r.lengths = append(r.lengths, l)
+			r.mtx.Unlock()
+		}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// take returns the sorted lengths recorded so far, and resets them.
+func (r *lengthRecorder) take() (l []int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	l, r.lengths = r.lengths, nil
+	sort.Ints(l)
+	return
+}
+
+// addr returns the server's address.
+func (r *lengthRecorder) addr() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+func TestSeedReproducesWorkload(t *testing.T) {
+	r := newLengthRecorder(t)
+	run := func(seed uint64) []int {
+		tst, err := NewTest(Params{
+			Addr:        r.addr(),
+			Duration:    250 * time.Millisecond,
+			MeanArrival: 5 * time.Millisecond,
+			Seed:        seed,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tst.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return r.take()
+	}
+	a := run(1)
+	if len(a) == 0 {
+		t.Fatal("no flows were run")
+	}
+	if b := run(1); !reflect.DeepEqual(a, b) {
+		t.Errorf("flow lengths differ with the same seed:\n%v\n%v", a, b)
+	}
+	if c := run(2); reflect.DeepEqual(a, c) {
+		t.Errorf("flow lengths are the same with different seeds: %v", a)
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	if NewSeed() == 0 {
+		t.Error("NewSeed returned zero")
+	}
+	tst, err := NewTest(Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tst.Seed == 0 {
+		t.Error("NewTest didn't choose a seed")
+	}
+}
+
+func TestLoadSetsMeanArrival(t *testing.T) {
+	tst, err := NewTest(Params{
+		LenModel:   LenWebSearch,
+		Bottleneck: 10 * bitrate.Mbps,
+		Load:       0.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := WebSearchCDF.Mean() * 8 / (0.5 * float64(10*bitrate.Mbps))
+	if want := time.Duration(sec * float64(time.Second)); tst.MeanArrival !=
+		want {
+		t.Errorf("mean arrival %s, want %s", tst.MeanArrival, want)
+	}
+	if math.Abs(tst.Utilization-0.5) > 0.001 {
+		t.Errorf("utilization %f, want 0.5", tst.Utilization)
+	}
+}
+
+func TestUtilizationRefusesOverload(t *testing.T) {
+	p := Params{
+		MeanArrival: time.Millisecond,
+		Bottleneck:  10 * bitrate.Mbps,
+	}
+	if tst, err := NewTest(p); err == nil {
+		t.Errorf("NewTest accepted a utilization of %f", tst.Utilization)
+	}
+	p.AllowOverload = true
+	tst, err := NewTest(p)
+	if err != nil {
+		t.Fatalf("NewTest with AllowOverload: %s", err)
+	}
+	if tst.Utilization <= 1 {
+		t.Errorf("utilization %f, want > 1", tst.Utilization)
+	}
+}
+
+func TestLoadRejected(t *testing.T) {
+	for _, p := range []Params{
+		{Load: 0.5},
+		{Load: -0.5, Bottleneck: 10 * bitrate.Mbps},
+		{Load: 1e15, Bottleneck: 10 * bitrate.Mbps, AllowOverload: true},
+	} {
+		if _, err := NewTest(p); err == nil {
+			t.Errorf("NewTest accepted load %g with bottleneck %s", p.Load,
+				p.Bottleneck)
+		}
+	}
+}
+
+// concurrencyRecorder is an HTTP server that delays each response, and records
+// the maximum number of concurrent requests.
+type concurrencyRecorder struct {
+	*httptest.Server
+	mtx     sync.Mutex
+	current int
+	max     int
+}
+
+// newConcurrencyRecorder returns a started concurrencyRecorder that delays
+// each response by delay, then responds with status.
+func newConcurrencyRecorder(t *testing.T, delay time.Duration,
+	status int) *concurrencyRecorder {
+	r := &concurrencyRecorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			r.mtx.Lock()
+			r.current++
+			if r.current > r.max {
+				r.max = r.current
+			}
+			r.mtx.Unlock()
+			time.Sleep(delay)
+			r.mtx.Lock()
+			r.current--
+			r.mtx.Unlock()
+			w.WriteHeader(status)
+		}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// maxConcurrent returns the maximum number of concurrent requests seen.
+func (r *concurrencyRecorder) maxConcurrent() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.max
+}
+
+func TestClosedLoopConcurrency(t *testing.T) {
+	r := newConcurrencyRecorder(t, 10*time.Millisecond, http.StatusOK)
+	tst, err := NewTest(Params{
+		Addr:        strings.TrimPrefix(r.URL, "http://"),
+		Duration:    200 * time.Millisecond,
+		Loop:        ClosedLoop,
+		Concurrency: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tst.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := r.maxConcurrent(); m != 3 {
+		t.Errorf("max concurrent flows %d, want 3", m)
+	}
+	// the slots run flows back to back, so should complete about 60 of them
+	if n := len(data.Flow); n < 3*10 {
+		t.Errorf("%d flows completed, want at least %d", n, 3*10)
+	}
+}
+
+func TestClosedLoopNoLoad(t *testing.T) {
+	p := Params{
+		Loop:       ClosedLoop,
+		Bottleneck: 10 * bitrate.Mbps,
+		Load:       0.5,
+	}
+	if _, err := NewTest(p); err == nil {
+		t.Error("NewTest accepted an offered load in closed-loop mode")
+	}
+	// this would overload the bottleneck in open-loop mode
+	p.Load = 0
+	p.MeanArrival = time.Nanosecond
+	tst, err := NewTest(p)
+	if err != nil {
+		t.Fatalf("NewTest refused a closed-loop test: %s", err)
+	}
+	if tst.Flows != 0 || tst.Bandwidth != 0 || tst.Utilization != 0 {
+		t.Errorf("closed-loop test has flows %d, bandwidth %s and "+
+			"utilization %f, want zero", tst.Flows, tst.Bandwidth,
+			tst.Utilization)
+	}
+	var b strings.Builder
+	tst.Emit(&b)
+	for _, s := range []string{"Flows:", "Mean arrival", "Est. bandwidth",
+		"Offered load"} {
+		if strings.Contains(b.String(), s) {
+			t.Errorf("closed-loop test output contains '%s':\n%s", s, b.String())
+		}
+	}
+}
+
+func TestMaxOutstandingDrops(t *testing.T) {
+	r := newConcurrencyRecorder(t, 50*time.Millisecond, http.StatusOK)
+	tst, err := NewTest(Params{
+		Addr:           strings.TrimPrefix(r.URL, "http://"),
+		Duration:       100 * time.Millisecond,
+		MeanArrival:    time.Millisecond,
+		Arrival:        ArrivalParams{Model: ArrivalConstant},
+		MaxOutstanding: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tst.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := r.maxConcurrent(); m > 2 {
+		t.Errorf("max concurrent flows %d, want at most 2", m)
+	}
+	if data.Dropped == 0 {
+		t.Error("no flows were dropped")
+	}
+	if n := len(data.Flow) + data.Dropped; n != tst.Flows {
+		t.Errorf("%d flows run or dropped, want %d", n, tst.Flows)
+	}
+}
+
+func TestOpenLoopLateError(t *testing.T) {
+	r := newConcurrencyRecorder(t, 50*time.Millisecond,
+		http.StatusInternalServerError)
+	tst, err := NewTest(Params{
+		Addr:        strings.TrimPrefix(r.URL, "http://"),
+		ReplayTrace: writeTrace(t, "0,1000\n0.001,1000\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both flows fail after the last arrival
+	if _, err = tst.Run(context.Background()); err == nil {
+		t.Error("Run succeeded with failed flows")
+	}
+}
+
+func TestFlowTiming(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	r := newConcurrencyRecorder(t, delay, http.StatusOK)
+	tst, err := NewTest(Params{
+		Addr:        strings.TrimPrefix(r.URL, "http://"),
+		ReplayTrace: writeTrace(t, "0,1000\n0.01,1000\n0.02,1000\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tst.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range data.Flow {
+		if !f.Timed() {
+			t.Errorf("flow has no timing breakdown: %+v", f)
+			continue
+		}
+		for _, p := range [][2]time.Time{
+			{f.Start, f.ConnectStart},
+			{f.ConnectStart, f.Connected},
+			{f.Connected, f.WroteRequest},
+			{f.WroteRequest, f.FirstByte},
+			{f.FirstByte, f.End},
+		} {
+			if p[1].Before(p[0]) {
+				t.Errorf("flow phases out of order: %+v", f)
+				break
+			}
+		}
+		if f.TTFB() < delay {
+			t.Errorf("TTFB %s is less than the server delay %s", f.TTFB(), delay)
+		}
+	}
+
+	data.AddFlow(Flow{Start: data.Start, End: data.Start.Add(time.Second)})
+	if n := len(data.TimedDurations(Flow.TTFB)); n != 3 {
+		t.Errorf("%d timed durations, want 3", n)
+	}
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	ccafct "github.com/heistp/fct"
+	"github.com/heistp/fct/bitrate"
+	"github.com/heistp/fct/metric"
+	"github.com/heistp/fct/unit"
+)
+
+// Duration is a time.Duration that is represented in JSON as a string parsed
+// by time.ParseDuration, e.g. "200ms" or "3m".
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"200ms\"")
+	}
+	var v time.Duration
+	if v, err = time.ParseDuration(s); err != nil {
+		return
+	}
+	*d = Duration(v)
+	return
+}
+
+// Bitrate is a bitrate.Bitrate that is represented in JSON as a string parsed
+// by bitrate.Parse, e.g. "50Mbps", or as a number in bits per second.
+type Bitrate bitrate.Bitrate
+
+// MarshalJSON implements json.Marshaler.
+func (b Bitrate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(bitrate.Bitrate(b).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *Bitrate) UnmarshalJSON(d []byte) (err error) {
+	var n float64
+	if err = json.Unmarshal(d, &n); err == nil {
+		if n < 0 || n >= math.MaxInt64 {
+			return fmt.Errorf("bitrate out of range: %s", d)
+		}
+		*b = Bitrate(n)
+		return
+	}
+	var s string
+	if err = json.Unmarshal(d, &s); err != nil {
+		return fmt.Errorf("bitrate must be a string, e.g. \"50Mbps\", or a number")
+	}
+	var v bitrate.Bitrate
+	if v, err = bitrate.Parse(s); err != nil {
+		return
+	}
+	*b = Bitrate(v)
+	return
+}
+
+// ArrivalConfig contains the arrival process parameters. See
+// ccafct.ArrivalParams.
+type ArrivalConfig struct {
+	Model       string
+	ParetoShape float64
+	OnMean      Duration
+	OffMean     Duration
+	BurstRatio  float64
+	HighMean    Duration
+	LowMean     Duration
+}
+
+// arrivalConfig returns an ArrivalConfig from ccafct.ArrivalParams.
+func arrivalConfig(p ccafct.ArrivalParams) ArrivalConfig {
+	return ArrivalConfig{
+		p.Model,
+		p.ParetoShape,
+		Duration(p.OnMean),
+		Duration(p.OffMean),
+		p.BurstRatio,
+		Duration(p.HighMean),
+		Duration(p.LowMean),
+	}
+}
+
+// params returns the ccafct.ArrivalParams for the ArrivalConfig.
+func (a ArrivalConfig) params() ccafct.ArrivalParams {
+	return ccafct.ArrivalParams{
+		Model:       a.Model,
+		ParetoShape: a.ParetoShape,
+		OnMean:      time.Duration(a.OnMean),
+		OffMean:     time.Duration(a.OffMean),
+		BurstRatio:  a.BurstRatio,
+		HighMean:    time.Duration(a.HighMean),
+		LowMean:     time.Duration(a.LowMean),
+	}
+}
+
+// Config is an experiment configuration, read from a JSON file with the
+// -config flag. Fields that are omitted from the file keep the defaults, which
+// are the globals at the top of main.go.
+type Config struct {
+	// RTT is the RTTs to test.
+	RTT []Duration
+
+	// Bandwidth is the simulated bottleneck link bandwidth.
+	Bandwidth Bitrate
+
+	// Qdisc is the queueing discipline to use at the bottleneck.
+	Qdisc string
+
+	// CCA are the competitors to test, each a CCA or a competing flow spec,
+	// e.g. "4xbbr:every=2s". The -cca flag takes precedence, if given.
+	CCA []string
+
+	// FCTDur is the duration to run the FCT test.
+	FCTDur Duration
+
+	// FCTMeanArrival is the mean time between new flow arrivals.
+	FCTMeanArrival Duration
+
+	// FCTLoad, if set, is the offered load as a fraction of Bandwidth, e.g.
+	// 0.5, from which the mean arrival time is calculated instead of using
+	// FCTMeanArrival.
+	FCTLoad float64
+
+	// FCTLoads, if set, is a list of offered loads to sweep, e.g.
+	// [0.1, 0.3, 0.5, 0.7, 0.9], in place of FCTLoad. It may not be used
+	// with FCTLoad, FCTRecordTrace or FCTReplayTrace.
+	FCTLoads []float64
+
+	// FCTAllowOverload permits an offered load greater than Bandwidth.
+	FCTAllowOverload bool
+
+	// FCTLoop is the workload loop mode, either open or closed. Closed-loop
+	// mode may not be used with FCTLoad or FCTLoads.
+	FCTLoop string
+
+	// FCTConcurrency is the number of request slots in closed-loop mode.
+	FCTConcurrency int
+
+	// FCTThinkTime is the mean think time between flows in closed-loop mode.
+	FCTThinkTime Duration
+
+	// FCTMaxOutstanding, if set, is the maximum number of outstanding flows
+	// in open-loop mode.
+	FCTMaxOutstanding int
+
+	// FCTArrival contains the arrival process parameters, e.g.
+	// {"Model": "mmpp", "BurstRatio": 20}.
+	FCTArrival ArrivalConfig
+
+	// FCTLenModel is the flow length model, either lognormal, websearch,
+	// datamining or cdf.
+	FCTLenModel string
+
+	// FCTLenCDFFile is the flow length CDF file for the cdf model.
+	FCTLenCDFFile string
+
+	// FCTLenP5 is the 5th percentile flow length in the lognormal
+	// distribution.
+	FCTLenP5 unit.Bytes
+
+	// FCTLenP95 is the 95th percentile flow length in the lognormal
+	// distribution.
+	FCTLenP95 unit.Bytes
+
+	// FCTTimeout is how long to wait after FCTDur for the FCT test to
+	// complete.
+	FCTTimeout Duration
+
+	// FCTCCA is the CC algorithm to use for all FCT flows.
+	FCTCCA string
+
+	// FCTCCAMix, if set, is a weighted mix of CCAs for the FCT flows, e.g.
+	// [{"CCA": "cubic", "Weight": 70}, {"CCA": "bbr", "Weight": 30}].
+	FCTCCAMix ccafct.CCAMix
+
+	// FCTProtocol is the FCT protocol, either http or tcp.
+	FCTProtocol string
+
+	// FCTZeroCopy, if true, runs the fct server in zero-copy mode. It
+	// requires the tcp FCTProtocol.
+	FCTZeroCopy bool
+
+	// FCTServerCPU, if true, records the fct server's CPU time per flow.
+	FCTServerCPU bool
+
+	// FCTDirection is the FCT flow direction, either download or upload.
+	FCTDirection string
+
+	// FCTStats is the set of statistics computed for the FCT results, e.g.
+	// ["median", "p99", "p99.9", "mean", "stddev"].
+	FCTStats ccafct.StatSet
+
+	// TimingStats is the set of statistics computed for each phase in the
+	// flow timing breakdown.
+	TimingStats ccafct.StatSet
+
+	// SockOptProfiles are the named socket option profiles, e.g.
+	// {"ef": {"Server": {"TOS": 184}, "Client": {"TOS": 184}}}.
+	SockOptProfiles map[string]SockOptProfile
+
+	// FCTProfile, if set, names the socket option profile for the FCT flows.
+	FCTProfile string
+
+	// CompetitorProfile, if set, names the socket option profile for the
+	// competitor flow.
+	CompetitorProfile string
+
+	// CompetitorInterval is the competitor's sample interval.
+	CompetitorInterval Duration
+
+	// ProbeInterval is the interval between latency probe packets.
+	ProbeInterval Duration
+
+	// ProbeSize is the latency probe packet size, in bytes of UDP payload.
+	ProbeSize int
+
+	// ProbeDur is how long to run the latency probe alone for each RTT.
+	ProbeDur Duration
+
+	// SaturationThreshold is the fraction of the expected bottleneck
+	// utilization below which a test is flagged as unsaturated.
+	SaturationThreshold float64
+
+	// QdiscInterval is the interval at which the bottleneck qdisc stats are
+	// sampled.
+	QdiscInterval Duration
+
+	// QdiscLog, if set, is a file to write the bottleneck qdisc samples to,
+	// as NDJSON.
+	QdiscLog string
+
+	// FCTSeed seeds the FCT workload. If zero, a seed is chosen at startup.
+	FCTSeed uint64
+
+	// FCTRecordTrace, if set, is a file to record the FCT workload schedule
+	// to.
+	FCTRecordTrace string
+
+	// FCTReplayTrace, if set, is a CSV trace of timestamp,size to replay as
+	// the FCT workload, which runs for the trace's span instead of FCTDur.
+	FCTReplayTrace string
+
+	// SlowStartDelay is a delay long enough for the CCA to exit slow start.
+	SlowStartDelay Duration
+}
+
+// currentConfig returns a Config containing the values of the globals.
+func currentConfig() (c Config) {
+	for _, r := range RTT {
+		c.RTT = append(c.RTT, Duration(r))
+	}
+	c.Bandwidth = Bitrate(Bandwidth)
+	c.Qdisc = Qdisc
+	c.CCA = append(c.CCA, CCA...)
+	c.FCTDur = Duration(FCTDur)
+	c.FCTMeanArrival = Duration(FCTMeanArrival)
+	c.FCTLoad = FCTLoad
+	c.FCTLoads = append(c.FCTLoads, FCTLoads...)
+	c.FCTAllowOverload = FCTAllowOverload
+	c.FCTLoop = FCTLoop
+	c.FCTConcurrency = FCTConcurrency
+	c.FCTThinkTime = Duration(FCTThinkTime)
+	c.FCTMaxOutstanding = FCTMaxOutstanding
+	c.FCTArrival = arrivalConfig(FCTArrival)
+	c.FCTLenModel = FCTLenModel
+	c.FCTLenCDFFile = FCTLenCDFFile
+	c.FCTLenP5 = FCTLenP5
+	c.FCTLenP95 = FCTLenP95
+	c.FCTTimeout = Duration(FCTTimeout)
+	c.FCTCCA = FCTCCA
+	c.FCTCCAMix = append(c.FCTCCAMix, FCTCCAMix...)
+	c.FCTProtocol = FCTProtocol
+	c.FCTZeroCopy = FCTZeroCopy
+	c.FCTServerCPU = FCTServerCPU
+	c.FCTDirection = FCTDirection
+	c.FCTStats = append(c.FCTStats, FCTStats...)
+	c.TimingStats = append(c.TimingStats, TimingStats...)
+	c.SockOptProfiles = make(map[string]SockOptProfile)
+	for n, p := range SockOptProfiles {
+		c.SockOptProfiles[n] = p
+	}
+	c.FCTProfile = FCTProfile
+	c.CompetitorProfile = CompetitorProfile
+	c.CompetitorInterval = Duration(CompetitorInterval)
+	c.ProbeInterval = Duration(ProbeInterval)
+	c.ProbeSize = ProbeSize
+	c.ProbeDur = Duration(ProbeDur)
+	c.SaturationThreshold = SaturationThreshold
+	c.QdiscInterval = Duration(QdiscInterval)
+	c.QdiscLog = QdiscLog
+	c.FCTSeed = FCTSeed
+	c.FCTRecordTrace = FCTRecordTrace
+	c.FCTReplayTrace = FCTReplayTrace
+	c.SlowStartDelay = Duration(SlowStartDelay)
+	return
+}
+
+// apply sets the globals from the Config.
+func (c Config) apply() {
+	RTT = RTT[:0]
+	for _, r := range c.RTT {
+		RTT = append(RTT, metric.Duration(r))
+	}
+	Bandwidth = bitrate.Bitrate(c.Bandwidth)
+	Qdisc = c.Qdisc
+	CCA = append([]string{}, c.CCA...)
+	FCTDur = time.Duration(c.FCTDur)
+	FCTMeanArrival = time.Duration(c.FCTMeanArrival)
+	FCTLoad = c.FCTLoad
+	FCTLoads = append([]float64{}, c.FCTLoads...)
+	FCTAllowOverload = c.FCTAllowOverload
+	FCTLoop = c.FCTLoop
+	FCTConcurrency = c.FCTConcurrency
+	FCTThinkTime = time.Duration(c.FCTThinkTime)
+	FCTMaxOutstanding = c.FCTMaxOutstanding
+	FCTArrival = c.FCTArrival.params()
+	FCTLenModel = c.FCTLenModel
+	FCTLenCDFFile = c.FCTLenCDFFile
+	FCTLenP5 = c.FCTLenP5
+	FCTLenP95 = c.FCTLenP95
+	FCTTimeout = time.Duration(c.FCTTimeout)
+	FCTCCA = c.FCTCCA
+	FCTCCAMix = c.FCTCCAMix
+	FCTProtocol = c.FCTProtocol
+	FCTZeroCopy = c.FCTZeroCopy
+	FCTServerCPU = c.FCTServerCPU
+	FCTDirection = c.FCTDirection
+	FCTStats = append(ccafct.StatSet{}, c.FCTStats...)
+	TimingStats = append(ccafct.StatSet{}, c.TimingStats...)
+	SockOptProfiles = c.SockOptProfiles
+	FCTProfile = c.FCTProfile
+	CompetitorProfile = c.CompetitorProfile
+	CompetitorInterval = time.Duration(c.CompetitorInterval)
+	ProbeInterval = time.Duration(c.ProbeInterval)
+	ProbeSize = c.ProbeSize
+	ProbeDur = time.Duration(c.ProbeDur)
+	SaturationThreshold = c.SaturationThreshold
+	QdiscInterval = time.Duration(c.QdiscInterval)
+	QdiscLog = c.QdiscLog
+	FCTSeed = c.FCTSeed
+	FCTRecordTrace = c.FCTRecordTrace
+	FCTReplayTrace = c.FCTReplayTrace
+	SlowStartDelay = time.Duration(c.SlowStartDelay)
+}
+
+// configErrors accumulates configuration errors, with the file name and line
+// number of the field they refer to, if known.
+type configErrors struct {
+	file  string
+	lines map[string]int
+	errs  []string
+}
+
+// addf adds an error for the named field.
+func (e *configErrors) addf(field, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if l, ok := e.lines[field]; ok {
+		e.errs = append(e.errs, fmt.Sprintf("%s:%d: %s: %s", e.file, l,
+			field, msg))
+		return
+	}
+	e.errs = append(e.errs, fmt.Sprintf("%s: %s: %s", e.file, field, msg))
+}
+
+// err returns an error containing all errors, or nil if there were none.
+func (e *configErrors) err() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(e.errs, "\n"))
+}
+
+// validate checks the Config for invalid values.
+func (c Config) validate(e *configErrors) {
+	if len(c.RTT) == 0 {
+		e.addf("RTT", "at least one RTT is required")
+	}
+	for _, r := range c.RTT {
+		if r <= 0 {
+			e.addf("RTT", "RTT must be positive: %s", time.Duration(r))
+		}
+	}
+	if c.Bandwidth <= 0 {
+		e.addf("Bandwidth", "must be positive")
+	}
+	if strings.TrimSpace(c.Qdisc) == "" {
+		e.addf("Qdisc", "must not be empty")
+	}
+	for _, cca := range c.CCA {
+		if strings.TrimSpace(cca) == "" {
+			e.addf("CCA", "CCA names must not be empty")
+		} else if err := checkCompetitor(cca,
+			time.Duration(c.SlowStartDelay+c.FCTDur)); err != nil {
+			e.addf("CCA", "%s", err)
+		}
+	}
+	if c.FCTDur <= 0 {
+		e.addf("FCTDur", "must be positive")
+	}
+	if c.FCTMeanArrival <= 0 {
+		e.addf("FCTMeanArrival", "must be positive")
+	}
+	if c.FCTLoad < 0 {
+		e.addf("FCTLoad", "must not be negative")
+	}
+	if c.FCTLoad > 1 && !c.FCTAllowOverload {
+		e.addf("FCTLoad", "offered load over 100%% requires FCTAllowOverload")
+	}
+	seen := make(map[float64]bool)
+	for _, l := range c.FCTLoads {
+		if l <= 0 {
+			e.addf("FCTLoads", "loads must be positive: %g", l)
+		}
+		if l > 1 && !c.FCTAllowOverload {
+			e.addf("FCTLoads",
+				"offered load over 100%% requires FCTAllowOverload: %g", l)
+		}
+		if seen[l] {
+			e.addf("FCTLoads", "duplicate load: %g", l)
+		}
+		seen[l] = true
+	}
+	if c.FCTLoad != 0 && len(c.FCTLoads) > 0 {
+		e.addf("FCTLoads", "may not be used with FCTLoad")
+	}
+	if c.FCTReplayTrace != "" && (c.FCTLoad != 0 || len(c.FCTLoads) > 0) {
+		e.addf("FCTReplayTrace", "may not be used with FCTLoad or FCTLoads, "+
+			"as the trace sets the offered load")
+	}
+	if c.FCTRecordTrace != "" && len(c.FCTLoads) > 0 {
+		e.addf("FCTRecordTrace", "may not be used with FCTLoads, as each load "+
+			"has a different workload")
+	}
+	switch c.FCTLoop {
+	case ccafct.OpenLoop:
+	case ccafct.ClosedLoop:
+		if c.FCTLoad != 0 || len(c.FCTLoads) > 0 {
+			e.addf("FCTLoop", "closed-loop mode may not be used with FCTLoad "+
+				"or FCTLoads, as it has no offered load")
+		}
+	default:
+		e.addf("FCTLoop", "must be %s or %s", ccafct.OpenLoop,
+			ccafct.ClosedLoop)
+	}
+	if c.FCTConcurrency < 1 {
+		e.addf("FCTConcurrency", "must be positive")
+	}
+	if c.FCTThinkTime < 0 {
+		e.addf("FCTThinkTime", "must not be negative")
+	}
+	if c.FCTMaxOutstanding < 0 {
+		e.addf("FCTMaxOutstanding", "must not be negative")
+	}
+	if err := c.FCTArrival.params().Validate(); err != nil {
+		e.addf("FCTArrival", "%s", err)
+	}
+	if _, err := ccafct.LenModelCDF(c.FCTLenModel,
+		c.FCTLenCDFFile); err != nil {
+		e.addf("FCTLenModel", "%s", err)
+	}
+	if c.FCTLenP5 <= 0 {
+		e.addf("FCTLenP5", "must be positive")
+	}
+	if c.FCTLenP95 <= c.FCTLenP5 {
+		e.addf("FCTLenP95", "must be greater than FCTLenP5 (%d)", c.FCTLenP5)
+	}
+	if c.FCTTimeout < 0 {
+		e.addf("FCTTimeout", "must not be negative")
+	}
+	if strings.TrimSpace(c.FCTCCA) == "" {
+		e.addf("FCTCCA", "must not be empty")
+	}
+	if len(c.FCTCCAMix) > 0 {
+		if err := c.FCTCCAMix.Validate(); err != nil {
+			e.addf("FCTCCAMix", "%s", err)
+		}
+	}
+	switch c.FCTProtocol {
+	case ccafct.ProtocolHTTP, ccafct.ProtocolTCP:
+	default:
+		e.addf("FCTProtocol", "must be %s or %s, not '%s'",
+			ccafct.ProtocolHTTP, ccafct.ProtocolTCP, c.FCTProtocol)
+	}
+	if c.FCTZeroCopy && c.FCTProtocol != ccafct.ProtocolTCP {
+		e.addf("FCTZeroCopy", "requires the %s FCTProtocol",
+			ccafct.ProtocolTCP)
+	}
+	switch c.FCTDirection {
+	case ccafct.Download, ccafct.Upload:
+	default:
+		e.addf("FCTDirection", "must be %s or %s, not '%s'",
+			ccafct.Download, ccafct.Upload, c.FCTDirection)
+	}
+	if err := c.FCTStats.Validate(); err != nil {
+		e.addf("FCTStats", "%s", err)
+	}
+	if err := c.TimingStats.Validate(); err != nil {
+		e.addf("TimingStats", "%s", err)
+	}
+	for n, p := range c.SockOptProfiles {
+		if err := p.Server.Validate(); err != nil {
+			e.addf("SockOptProfiles", "%s: Server: %s", n, err)
+		}
+		if p.Server.CCA != "" {
+			e.addf("SockOptProfiles", "%s: Server: CCA may not be set", n)
+		}
+		if err := p.Client.Validate(); err != nil {
+			e.addf("SockOptProfiles", "%s: Client: %s", n, err)
+		}
+	}
+	if _, ok := c.SockOptProfiles[c.FCTProfile]; c.FCTProfile != "" && !ok {
+		e.addf("FCTProfile", "unknown profile: '%s'", c.FCTProfile)
+	}
+	if _, ok := c.SockOptProfiles[c.CompetitorProfile]; c.CompetitorProfile !=
+		"" && !ok {
+		e.addf("CompetitorProfile", "unknown profile: '%s'",
+			c.CompetitorProfile)
+	}
+	if c.CompetitorInterval <= 0 {
+		e.addf("CompetitorInterval", "must be positive")
+	}
+	if c.ProbeInterval <= 0 {
+		e.addf("ProbeInterval", "must be positive")
+	}
+	if c.ProbeSize < ccafct.MinProbeSize {
+		e.addf("ProbeSize", "must be at least %d", ccafct.MinProbeSize)
+	}
+	if c.ProbeDur <= 0 {
+		e.addf("ProbeDur", "must be positive")
+	}
+	if c.SaturationThreshold < 0 || c.SaturationThreshold > 1 {
+		e.addf("SaturationThreshold", "must be from 0 to 1")
+	}
+	if c.QdiscInterval <= 0 {
+		e.addf("QdiscInterval", "must be positive")
+	}
+	if c.FCTReplayTrace != "" {
+		if _, err := ccafct.ReadTraceFile(c.FCTReplayTrace); err != nil {
+			e.addf("FCTReplayTrace", "%s", err)
+		}
+	}
+	if c.SlowStartDelay < 0 {
+		e.addf("SlowStartDelay", "must not be negative")
+	}
+}
+
+// loadConfig reads a JSON Config from the named file, starting from the
+// current values of the globals, validates it, and returns it along with the
+// file's contents. Each top-level field is decoded separately, so errors can
+// be reported with the line number they occur on.
+func loadConfig(file string) (cfg Config, raw []byte, err error) {
+	if raw, err = ioutil.ReadFile(file); err != nil {
+		return
+	}
+	cfg = currentConfig()
+	e := &configErrors{file: file, lines: make(map[string]int)}
+
+	lineAt := func(off int64) int {
+		if off > int64(len(raw)) {
+			off = int64(len(raw))
+		}
+		return 1 + bytes.Count(raw[:off], []byte{'\n'})
+	}
+	syntaxErr := func(serr error) error {
+		var se *json.SyntaxError
+		if errors.As(serr, &se) {
+			return fmt.Errorf("%s:%d: %s", file, lineAt(se.Offset), se)
+		}
+		return fmt.Errorf("%s: %s", file, serr)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	var tok json.Token
+	if tok, err = dec.Token(); err != nil {
+		err = syntaxErr(err)
+		return
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		err = fmt.Errorf("%s:%d: expected a JSON object", file,
+			lineAt(dec.InputOffset()))
+		return
+	}
+
+	cv := reflect.ValueOf(&cfg).Elem()
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			err = syntaxErr(err)
+			return
+		}
+		key := tok.(string)
+		line := lineAt(dec.InputOffset())
+
+		var val json.RawMessage
+		if err = dec.Decode(&val); err != nil {
+			err = syntaxErr(err)
+			return
+		}
+		valOff := dec.InputOffset() - int64(len(val))
+
+		var field reflect.Value
+		var name string
+		for i := 0; i < cv.NumField(); i++ {
+			if strings.EqualFold(cv.Type().Field(i).Name, key) {
+				field = cv.Field(i)
+				name = cv.Type().Field(i).Name
+				break
+			}
+		}
+		if !field.IsValid() {
+			e.errs = append(e.errs, fmt.Sprintf("%s:%d: unknown field '%s'",
+				file, line, key))
+			continue
+		}
+		e.lines[name] = line
+
+		fdec := json.NewDecoder(bytes.NewReader(val))
+		fdec.DisallowUnknownFields()
+		if uerr := fdec.Decode(field.Addr().Interface()); uerr != nil {
+			var te *json.UnmarshalTypeError
+			if errors.As(uerr, &te) {
+				e.lines[name] = lineAt(valOff + te.Offset)
+				e.addf(name, "cannot use JSON %s as %s", te.Value, te.Type)
+			} else {
+				e.addf(name, "%s", uerr)
+			}
+		}
+	}
+	if _, err = dec.Token(); err != nil {
+		err = syntaxErr(err)
+		return
+	}
+	if _, terr := dec.Token(); terr == nil {
+		err = fmt.Errorf("%s:%d: unexpected data after JSON object", file,
+			lineAt(dec.InputOffset()))
+		return
+	}
+
+	if err = e.err(); err != nil {
+		return
+	}
+	cfg.validate(e)
+	err = e.err()
+
+	return
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/heistp/fct/bitrate"
+)
+
+// writeConfig writes a config file to a temporary directory, and returns its
+// name.
+func writeConfig(t *testing.T, text string) string {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(f, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	want := currentConfig()
+	b, err := json.MarshalIndent(want, "", "    ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := loadConfig(writeConfig(t, string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("loaded %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadConfigKeepsDefaults(t *testing.T) {
+	cfg, _, err := loadConfig(writeConfig(t, `{
+    "fctdur": "1m",
+    "Bandwidth": 2.5e7
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Duration(cfg.FCTDur); d != time.Minute {
+		t.Errorf("FCTDur %s, want %s", d, time.Minute)
+	}
+	if b := bitrate.Bitrate(cfg.Bandwidth); b != 25*bitrate.Mbps {
+		t.Errorf("Bandwidth %s, want %s", b, 25*bitrate.Mbps)
+	}
+	if cfg.Qdisc != Qdisc {
+		t.Errorf("Qdisc '%s', want default '%s'", cfg.Qdisc, Qdisc)
+	}
+	if time.Duration(cfg.FCTMeanArrival) != FCTMeanArrival {
+		t.Errorf("FCTMeanArrival %s, want default %s",
+			time.Duration(cfg.FCTMeanArrival), FCTMeanArrival)
+	}
+}
+
+func TestLoadConfigReportsEachLine(t *testing.T) {
+	f := writeConfig(t, `{
+    "FCTDur": "1m",
+    "Bogus": 1,
+    "Bandwidth": "50Mbs",
+    "FCTCCA": 3
+}`)
+	_, _, err := loadConfig(f)
+	if err == nil {
+		t.Fatal("loaded a config with errors")
+	}
+	got := strings.Split(err.Error(), "\n")
+	want := []string{
+		f + ":3: unknown field 'Bogus'",
+		f + ":4: Bandwidth: invalid bitrate: '50Mbs'",
+		f + ":5: FCTCCA: cannot use JSON number as string",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"),
+			strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadConfigValidates(t *testing.T) {
+	f := writeConfig(t, `{"RTT": [], "FCTDur": "0s"}`)
+	_, _, err := loadConfig(f)
+	if err == nil {
+		t.Fatal("loaded an invalid config")
+	}
+	for _, s := range []string{
+		f + ":1: RTT: at least one RTT is required",
+		f + ":1: FCTDur: must be positive",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error '%s' doesn't contain '%s'", err, s)
+		}
+	}
+}
+
+func TestLoadConfigSweepWithTrace(t *testing.T) {
+	tr := filepath.Join(t.TempDir(), "trace.csv")
+	if err := os.WriteFile(tr, []byte("0,1000\n1,2000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		json  string
+		field string
+	}{
+		{`{"FCTLoads": [0.2, 0.4], "FCTReplayTrace": "` + tr + `"}`,
+			"FCTReplayTrace"},
+		{`{"FCTLoad": 0.5, "FCTReplayTrace": "` + tr + `"}`,
+			"FCTReplayTrace"},
+		{`{"FCTLoads": [0.2, 0.4], "FCTRecordTrace": "rec.csv"}`,
+			"FCTRecordTrace"},
+	} {
+		_, _, err := loadConfig(writeConfig(t, c.json))
+		if err == nil {
+			t.Errorf("loaded %s", c.json)
+		} else if !strings.Contains(err.Error(), c.field+": may not be used") {
+			t.Errorf("loading %s: error '%s' isn't for %s", c.json, err,
+				c.field)
+		}
+	}
+	if _, _, err := loadConfig(writeConfig(t,
+		`{"FCTLoad": 0.5, "FCTRecordTrace": "rec.csv"}`)); err != nil {
+		t.Errorf("recording a single load: %s", err)
+	}
+}
+
+func TestLoadConfigLoadAndLoads(t *testing.T) {
+	f := writeConfig(t, `{
+    "FCTLoad": 0.5,
+    "FCTLoads": [0.2, 0.4]
+}`)
+	_, _, err := loadConfig(f)
+	if err == nil {
+		t.Fatal("loaded both FCTLoad and FCTLoads")
+	}
+	if s := f + ":3: FCTLoads: may not be used with FCTLoad"; !strings.Contains(
+		err.Error(), s) {
+		t.Errorf("error '%s' doesn't contain '%s'", err, s)
+	}
+}
+
+func TestLoadConfigLoopMode(t *testing.T) {
+	f := writeConfig(t, `{
+    "FCTLoop": "half-open",
+    "FCTConcurrency": 0,
+    "FCTThinkTime": "-1s",
+    "FCTMaxOutstanding": -1
+}`)
+	_, _, err := loadConfig(f)
+	if err == nil {
+		t.Fatal("loaded an invalid loop mode")
+	}
+	for _, s := range []string{
+		f + ":2: FCTLoop: must be open or closed",
+		f + ":3: FCTConcurrency: must be positive",
+		f + ":4: FCTThinkTime: must not be negative",
+		f + ":5: FCTMaxOutstanding: must not be negative",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error '%s' doesn't contain '%s'", err, s)
+		}
+	}
+}
+
+func TestLoadConfigClosedLoopLoad(t *testing.T) {
+	for _, j := range []string{
+		`{"FCTLoop": "closed", "FCTLoad": 0.5}`,
+		`{"FCTLoop": "closed", "FCTLoads": [0.2, 0.4]}`,
+	} {
+		_, _, err := loadConfig(writeConfig(t, j))
+		if err == nil {
+			t.Errorf("loaded %s", j)
+		} else if !strings.Contains(err.Error(),
+			"FCTLoop: closed-loop mode may not be used") {
+			t.Errorf("loading %s: error '%s' isn't for FCTLoop", j, err)
+		}
+	}
+}
+
+func TestLoadConfigProfiles(t *testing.T) {
+	cfg, _, err := loadConfig(writeConfig(t, `{
+    "SockOptProfiles": {
+        "ef": {"Server": {"TOS": 184}, "Client": {"TOS": 184}},
+        "paced": {"Server": {"MaxPacingRate": 20000000}}
+    },
+    "FCTProfile": "ef",
+    "CompetitorProfile": "paced"
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := cfg.SockOptProfiles["ef"].Client; o.TOS != 184 {
+		t.Errorf("ef client TOS %d, want 184", o.TOS)
+	}
+	if r := cfg.SockOptProfiles["paced"].Server.MaxPacingRate; r !=
+		20*bitrate.Mbps {
+		t.Errorf("paced max pacing rate %s, want 20Mbps", r)
+	}
+
+	f := writeConfig(t, `{
+    "SockOptProfiles": {
+        "bad": {"Server": {"CCA": "bbr", "TOS": 256}},
+        "client": {"Client": {"TOS": 184}}
+    },
+    "FCTProfile": "nosuch",
+    "CompetitorProfile": "missing"
+}`)
+	_, _, err = loadConfig(f)
+	if err == nil {
+		t.Fatal("loaded invalid profiles")
+	}
+	for _, s := range []string{
+		"SockOptProfiles: bad: Server: TOS out of range: 256",
+		"SockOptProfiles: bad: Server: CCA may not be set",
+		"FCTProfile: unknown profile: 'nosuch'",
+		"CompetitorProfile: unknown profile: 'missing'",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error '%s' doesn't contain '%s'", err, s)
+		}
+	}
+	if len(SockOptProfiles) != 0 {
+		t.Errorf("loading changed the global profiles: %v", SockOptProfiles)
+	}
+}
+
+func TestLoadConfigCompetitorSpec(t *testing.T) {
+	if _, _, err := loadConfig(writeConfig(t, `{
+    "CCA": ["cubic", "cubic+2xbbr:start=5s:every=5s"],
+    "SlowStartDelay": "5s",
+    "FCTDur": "20s"
+}`)); err != nil {
+		t.Fatal(err)
+	}
+	f := writeConfig(t, `{
+    "CCA": ["cubic:fast", "4xbbr:every=10s"],
+    "SlowStartDelay": "5s",
+    "FCTDur": "20s"
+}`)
+	_, _, err := loadConfig(f)
+	if err == nil {
+		t.Fatal("loaded invalid competitor specs")
+	}
+	for _, s := range []string{
+		"CCA: unknown option 'fast'",
+		"CCA: last flow starts at 30s, but the competitor only runs for 25s",
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error '%s' doesn't contain '%s'", err, s)
+		}
+	}
+}
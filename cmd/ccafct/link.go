@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	ccafct "github.com/heistp/fct"
+	"github.com/heistp/fct/bitrate"
+	"github.com/heistp/fct/netns"
+	"github.com/heistp/fct/pretty"
+)
+
+// linkReading is a reading of the transmit counters for the bottleneck
+// devices.
+type linkReading struct {
+	time  time.Time
+	stats map[string]netns.LinkStats
+}
+
+// readLinks reads the counters for the bottleneck devices, by direction.
+func readLinks(rig *netns.Rig) (r linkReading, err error) {
+	r.stats = make(map[string]netns.LinkStats)
+	for _, b := range bottlenecks(rig) {
+		if r.stats[b.dir], err = rig.LinkStats(b.ns, b.dev); err != nil {
+			return
+		}
+	}
+	r.time = time.Now()
+	return
+}
+
+// linkUsage is the number of bytes sent by each bottleneck device between two
+// readings.
+type linkUsage struct {
+	dur   time.Duration
+	bytes map[string]uint64
+}
+
+// usage returns the link usage from an earlier reading to this one.
+func (r linkReading) usage(before linkReading) (u linkUsage) {
+	u.dur = r.time.Sub(before.time)
+	u.bytes = make(map[string]uint64)
+	for d, s := range r.stats {
+		u.bytes[d] = s.TxBytes - before.stats[d].TxBytes
+	}
+	return
+}
+
+// Utilization is the bottleneck utilization in the FCT direction during one
+// test, and how it was shared.
+type Utilization struct {
+	// Throughput is the rate the bottleneck device sent at, including
+	// headers and traffic other than the FCT workload and competitor.
+	Throughput bitrate.Bitrate
+
+	// Utilization is Throughput as a fraction of Bandwidth.
+	Utilization float64
+
+	// Expected is the expected utilization, which is 1 while a competitor
+	// flow in the FCT direction is active, or the offered load otherwise, up
+	// to 1, weighted by time.
+	Expected float64
+
+	// Offered is the FCT workload's estimated offered load.
+	Offered bitrate.Bitrate
+
+	// Goodput is the FCT workload's goodput.
+	Goodput bitrate.Bitrate
+
+	// Competitor is the throughput of the competitor flows in the FCT
+	// direction.
+	Competitor bitrate.Bitrate
+
+	// Unsaturated is true if Utilization was below Expected by more than
+	// the SaturationThreshold allows.
+	Unsaturated bool
+}
+
+// fctBottleneck returns the direction of the bottleneck the FCT flows' payloads
+// pass through.
+func fctBottleneck() string {
+	if FCTDirection == ccafct.Upload {
+		return BottleneckUp
+	}
+	return BottleneckDown
+}
+
+// utilization returns the bottleneck utilization for a test, with the
+// competitor's samples, if any.
+func utilization(u linkUsage, test *ccafct.Test, data *ccafct.Data,
+	comp []ccafct.BulkSample) (util Utilization) {
+	if u.dur > 0 {
+		util.Throughput = bitrate.Bitrate(float64(u.bytes[fctBottleneck()]) *
+			8 / u.dur.Seconds())
+	}
+	util.Utilization = float64(util.Throughput) / float64(Bandwidth)
+	util.Offered = test.Bandwidth
+	util.Goodput = data.Goodput()
+	comp = ccafct.BulkSamplesInDirection(ccafct.BulkSamplesBetween(comp,
+		data.Start, data.End), FCTDirection)
+	util.Competitor = ccafct.AnalyzeBulk(comp).Throughput.Bitrate
+	o := math.Min(float64(test.Bandwidth)/float64(Bandwidth), 1)
+	a := competitorActive(comp, data.Start, data.End)
+	util.Expected = a + (1-a)*o
+	util.Unsaturated = util.Utilization < util.Expected*SaturationThreshold
+	return
+}
+
+// competitorActive returns the fraction of the time from start to end that at
+// least one competitor flow was active, from the samples' intervals.
+func competitorActive(samples []ccafct.BulkSample, start,
+	end time.Time) float64 {
+	d := end.Sub(start)
+	if d <= 0 {
+		return 0
+	}
+	type span struct {
+		start, end time.Time
+	}
+	var spans []span
+	for _, s := range samples {
+		a, b := s.Time.Add(-s.Interval), s.Time
+		if a.Before(start) {
+			a = start
+		}
+		if b.After(end) {
+			b = end
+		}
+		if b.After(a) {
+			spans = append(spans, span{a, b})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start.Before(spans[j].start)
+	})
+	var active time.Duration
+	var cur span
+	for i, s := range spans {
+		if i == 0 || s.start.After(cur.end) {
+			active += cur.end.Sub(cur.start)
+			cur = s
+		} else if s.end.After(cur.end) {
+			cur.end = s.end
+		}
+	}
+	active += cur.end.Sub(cur.start)
+	return float64(active) / float64(d)
+}
+
+// Row returns the utilization as table columns.
+func (u Utilization) Row() []interface{} {
+	pct := func(f float64) string {
+		return pretty.Float64(f*100, 1) + "%"
+	}
+	comp := interface{}("-")
+	if u.Competitor > 0 {
+		comp = u.Competitor
+	}
+	note := ""
+	if u.Unsaturated {
+		note = fmt.Sprintf("unsaturated (expected %s)", pct(u.Expected))
+	}
+	return []interface{}{u.Throughput, pct(u.Utilization), u.Offered,
+		u.Goodput, comp, note}
+}
+
+// UtilizationHeader contains the column headers for Utilization.Row.
+var UtilizationHeader = []string{"Link Tput", "Utilization", "Offered",
+	"FCT Goodput", "Competitor Tput", "Note"}
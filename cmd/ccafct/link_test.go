@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	ccafct "github.com/heistp/fct"
+	"github.com/heistp/fct/bitrate"
+	"github.com/heistp/fct/netns"
+)
+
+func TestUtilization(t *testing.T) {
+	defer func(bw bitrate.Bitrate, dir string, thresh float64) {
+		Bandwidth, FCTDirection, SaturationThreshold = bw, dir, thresh
+	}(Bandwidth, FCTDirection, SaturationThreshold)
+	Bandwidth = 50 * bitrate.Mbps
+	SaturationThreshold = 0.9
+
+	t0 := time.Now()
+	before := linkReading{t0, map[string]netns.LinkStats{
+		BottleneckDown: {TxBytes: 1000000},
+		BottleneckUp:   {TxBytes: 2000000},
+	}}
+	after := linkReading{t0.Add(time.Second), map[string]netns.LinkStats{
+		BottleneckDown: {TxBytes: 6000000},
+		BottleneckUp:   {TxBytes: 2125000},
+	}}
+	u := after.usage(before)
+	test := &ccafct.Test{Bandwidth: 20 * bitrate.Mbps}
+	data := &ccafct.Data{Start: t0, End: t0.Add(time.Second)}
+	data.AddFlow(ccafct.Flow{Length: 125000})
+	data.AddFlow(ccafct.Flow{Length: 125000})
+
+	// competitor samples at 10Mbps for the whole test, or the first half
+	full := func(dir string) []ccafct.BulkSample {
+		return []ccafct.BulkSample{{Direction: dir, Time: t0.Add(time.Second),
+			Interval: time.Second, Bytes: 1250000}}
+	}
+	half := []ccafct.BulkSample{{Direction: ccafct.Download,
+		Time: t0.Add(500 * time.Millisecond), Interval: 500 * time.Millisecond,
+		Bytes: 625000}}
+
+	for _, c := range []struct {
+		name        string
+		dir         string
+		comp        []ccafct.BulkSample
+		throughput  bitrate.Bitrate
+		competitor  bitrate.Bitrate
+		expected    float64
+		unsaturated bool
+	}{
+		// 40Mbps is 80% of the link, twice the 40% offered load
+		{"alone", ccafct.Download, nil, 40 * bitrate.Mbps, 0, 0.4, false},
+		// but under 90% of the link, with competition
+		{"competing", ccafct.Download, full(ccafct.Download),
+			40 * bitrate.Mbps, 10 * bitrate.Mbps, 1, true},
+		// competition in the other direction doesn't fill the link
+		{"reverse", ccafct.Download, full(ccafct.Upload), 40 * bitrate.Mbps, 0,
+			0.4, false},
+		// competition for half the test expects half of each
+		{"half", ccafct.Download, half, 40 * bitrate.Mbps, 10 * bitrate.Mbps,
+			0.7, false},
+		{"upload", ccafct.Upload, nil, 1 * bitrate.Mbps, 0, 0.4, true},
+	} {
+		FCTDirection = c.dir
+		util := utilization(u, test, data, c.comp)
+		if util.Throughput != c.throughput {
+			t.Errorf("%s: throughput %s, want %s", c.name, util.Throughput,
+				c.throughput)
+		}
+		if util.Competitor != c.competitor {
+			t.Errorf("%s: competitor %s, want %s", c.name, util.Competitor,
+				c.competitor)
+		}
+		if math.Abs(util.Expected-c.expected) > 1e-9 {
+			t.Errorf("%s: expected %f, want %f", c.name, util.Expected,
+				c.expected)
+		}
+		if util.Unsaturated != c.unsaturated {
+			t.Errorf("%s: unsaturated %t, want %t", c.name, util.Unsaturated,
+				c.unsaturated)
+		}
+		if util.Goodput != 2*bitrate.Mbps {
+			t.Errorf("goodput %s, want 2Mbps", util.Goodput)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -34,7 +35,9 @@ var Bandwidth = 50 * bitrate.Mbps
 // Qdisc is the queueing discipline to use at the bottleneck.
 var Qdisc = "fq_codel flows 1"
 
-// CCA are the congestion control algorithms to test.
+// CCA are the competitors to test. Each is a CCA for a single competing flow,
+// or a spec of competing flows in the form read by ccafct.ParseBulkSpec, e.g.
+// "4xbbr:every=2s" for four BBR flows started two seconds apart.
 var CCA = []string{}
 
 // FCTDur is the duration to run the FCT test.
@@ -43,6 +46,45 @@ var FCTDur = 3 * time.Minute
 // FCTMeanArrival is the mean time between new flow arrivals.
 var FCTMeanArrival = 200 * time.Millisecond
 
+// FCTLoad, if set, is the FCT workload's offered load as a fraction of
+// Bandwidth, from which the mean arrival time is calculated instead of using
+// FCTMeanArrival.
+var FCTLoad float64
+
+// FCTLoads, if set, is a list of offered loads to sweep, as fractions of
+// Bandwidth. The solo and competition tests are repeated for each load, in
+// place of FCTLoad. It may not be used with FCTLoad, FCTRecordTrace or
+// FCTReplayTrace.
+var FCTLoads []float64
+
+// FCTAllowOverload permits an FCTLoad greater than 1, which is otherwise an
+// error.
+var FCTAllowOverload bool
+
+// FCTLoop is the FCT workload loop mode, either open or closed.
+var FCTLoop = ccafct.OpenLoop
+
+// FCTConcurrency is the number of request slots in closed-loop mode.
+var FCTConcurrency = ccafct.DefaultConcurrency
+
+// FCTThinkTime is the mean think time between flows in closed-loop mode.
+var FCTThinkTime time.Duration
+
+// FCTMaxOutstanding, if set, is the maximum number of outstanding flows in
+// open-loop mode. Flows arriving beyond the maximum are dropped.
+var FCTMaxOutstanding int
+
+// FCTArrival contains the FCT workload arrival process parameters. The default
+// is a Poisson process.
+var FCTArrival = ccafct.ArrivalParams{}
+
+// FCTLenModel is the FCT flow length model, either lognormal, websearch,
+// datamining or cdf.
+var FCTLenModel = ccafct.LenLogNormal
+
+// FCTLenCDFFile is the flow length CDF file for the cdf flow length model.
+var FCTLenCDFFile string
+
 // FCTLenP5 is the 5th percentile flow length in the lognormal distribution.
 var FCTLenP5 = 64 * unit.Kilobyte
 
@@ -58,16 +100,114 @@ var ContextTimeout = 30 * time.Second
 // FCTCCA is the CC algorithm to use for all FCT flows.
 var FCTCCA = "cubic"
 
+// FCTCCAMix, if set, is a weighted mix of CCAs for the FCT flows, used
+// instead of FCTCCA. FCT stats are then also given for each CCA in the mix.
+var FCTCCAMix ccafct.CCAMix
+
+// FCTProtocol is the FCT protocol, either http or tcp (the raw binary
+// protocol).
+var FCTProtocol = ccafct.ProtocolHTTP
+
+// FCTZeroCopy, if true, runs the fct server in zero-copy mode, which sends
+// payloads with sendfile to reduce server CPU use at high rates. It requires
+// the tcp FCTProtocol.
+var FCTZeroCopy bool
+
+// FCTServerCPU, if true, records the fct server's CPU time per flow, which is
+// reported with the transport stats. The server then uses a thread per active
+// flow.
+var FCTServerCPU bool
+
+// FCTDirection is the FCT flow direction, either download or upload. For
+// uploads, FCTCCA is used by the client in the left namespace.
+var FCTDirection = ccafct.Download
+
+// FCTStats is the set of statistics computed for the FCT results, with harm,
+// each given as a column in the results.
+var FCTStats = ccafct.DefaultStatSet
+
+// TimingStats is the set of statistics computed for each phase in the flow
+// timing breakdown.
+var TimingStats = ccafct.StatSet{ccafct.StatMedian, "p95"}
+
+// SockOptProfile is a named set of socket options for the server and client
+// sides of a flow.
+type SockOptProfile struct {
+	// Server contains the options for the server's sockets.
+	Server ccafct.SockOpts
+
+	// Client contains the options for the client's sockets.
+	Client ccafct.SockOpts
+}
+
+// SockOptProfiles are the named socket option profiles, which may be selected
+// with FCTProfile and CompetitorProfile.
+var SockOptProfiles = map[string]SockOptProfile{}
+
+// FCTProfile, if set, names the socket option profile for the FCT flows.
+var FCTProfile string
+
+// CompetitorProfile, if set, names the socket option profile for the
+// competitor flow.
+var CompetitorProfile string
+
+// CompetitorInterval is the interval at which the competitor's throughput and
+// TCP_INFO are sampled.
+var CompetitorInterval = 1 * time.Second
+
+// ProbeInterval is the interval between latency probe packets.
+var ProbeInterval = ccafct.DefaultProbeInterval
+
+// ProbeSize is the latency probe packet size, in bytes of UDP payload.
+var ProbeSize = ccafct.DefaultProbeSize
+
+// ProbeDur is how long to run the latency probe alone for each RTT, as the
+// baseline for RTT harm.
+var ProbeDur = 10 * time.Second
+
+// SaturationThreshold is the fraction of the expected bottleneck utilization
+// below which a test is flagged as unsaturated. The expected utilization is 1
+// while a competitor flow in the FCT direction is active, or the offered load
+// otherwise.
+var SaturationThreshold = 0.9
+
+// QdiscInterval is the interval at which the bottleneck qdisc stats are
+// sampled during each test.
+var QdiscInterval = 200 * time.Millisecond
+
+// QdiscLog, if set, is a file the bottleneck qdisc samples for each test are
+// written to, as NDJSON.
+var QdiscLog string
+
+// FCTSeed seeds the FCT workload, so the solo and competition runs see the
+// same flow arrivals and lengths. If zero, a seed is chosen at startup.
+var FCTSeed uint64
+
+// FCTRecordTrace, if set, is a file the fct client writes the FCT workload
+// schedule to, as a CSV of timestamp,size.
+var FCTRecordTrace string
+
+// FCTReplayTrace, if set, is a CSV trace of timestamp,size to replay as the FCT
+// workload, instead of sampling the arrival and flow length distributions.
+// FCTDur is then set to the trace's span by setTraceDur.
+var FCTReplayTrace string
+
 // SlowStartDelay is a delay long enough for the CCA to exit slow start.
 var SlowStartDelay = 20 * time.Second
 
+// ConfigFile is the name of the config file, if one was given.
+var ConfigFile string
+
+// ConfigText is the contents of the config file, if one was given.
+var ConfigText []byte
+
 // DefaultCompetitionCCA is the default long-running CCAs to test.
 const DefaultCompetitionCCA = "cubic"
 
 // Description is emitted in the usage and test output.
 const Description = `This tests FCT (flow completion time) for a baseline CCA (congestion
 control algorithm) through a single Codel queue, with and without the
-competition of a single flow from a selected competing CCA, and
+competition of one or more flows from a selected competing CCA, and
 measures the resulting harm to FCT. Network namespaces are used to
 simulate path delay.
 
@@ -79,22 +219,30 @@ The test process is as follows:
 1. Run a test FCT workload with the baseline CCA. This gives us the
    solo performance, without competition. Harm calculations are not
    made for this step, as there is no competition yet.
-2. Run the same FCT workload in competition with a single flow from
-   one of the competitor CCAs, as follows:
-   * Start one flow for the competing CCA, giving it enough time to
-     exit the slow-start phase.
+2. Run the same FCT workload in competition with one of the
+   competitors, as follows:
+   * Start the competing flows, giving them enough time to exit the
+     slow-start phase.
    * Start the FCT workload for the baseline CCA.
    * Wait for the baseline CCA to complete, or a timeout to expire.
-   * Terminate the competing CCA flow.
-   * Calculate the resulting FCT statistics and harm to FCT.
+   * Terminate the competing flows.
+   * Calculate the resulting FCT statistics and harm to FCT, and the
+     harm to the competitor's throughput.
 3. Run step 2 for each additional competitor CCA, sequentially.
 
 Multiple CCAs are tested sequentially, across multiple RTTs. The CCAs
 under test may be specified using the -cca flag at the command line.
-The FCT workload introduces flows with an exponential distribution,
-and chooses flow lengths with a lognormal distribution. These and
-other parameter changes must be made by modifying the globals at the
-top of the program's source code.
+Each may instead be a spec of multiple competing flows, with their own
+CCAs, start times, durations and directions, e.g. 4xbbr:every=2s for
+four BBR flows started two seconds apart. Start times are relative to
+the start of the competitor, not the FCT workload, and every flow must
+start before the workload ends. The FCT workload introduces
+flows with an exponential distribution by default, or a constant rate,
+Pareto on/off or Markov-modulated bursty arrival process. Flow lengths
+are chosen with a lognormal distribution by default, or the web search
+or data mining empirical distributions, or a CDF from a file. These
+and other parameters may be set in a JSON config file given with the
+-config flag, which is echoed in the test output.
 
 The harm calculations quantify the CCA's impact on the FCT results. As
 a "less is better" metric, FCT harm is calculated as:
@@ -102,13 +250,42 @@ a "less is better" metric, FCT harm is calculated as:
 (workload - solo) / workload
 
 where workload is the FCT in competition with the CCA under test, and
-solo is the baseline, without competition.`
+solo is the baseline, without competition.
+
+Harm is also calculated for the competitor, which is first run alone
+for each RTT. As a "more is better" metric, its throughput harm is:
+
+(solo - workload) / solo
+
+where workload is the competitor's throughput while the FCT workload
+runs, and solo is its throughput alone.
+
+A UDP latency probe runs between a separate pair of endpoints during
+each test, and is also run alone for each RTT. The RTT percentiles,
+jitter and loss it sees are reported with the results, with RTT harm
+relative to the probe alone, as the queueing delay that interactive
+traffic would see.
+
+The stats for the bottleneck qdiscs are sampled during each test, and
+the backlog, drops, ECN marks, HTB overlimits and active flow queues
+are summarized for each, to show whether the queue was dropping,
+marking or just deep.
+
+The bottleneck's interface counters are read before and after each
+test, and its utilization is shown with the FCT workload's offered load
+and goodput, and the competitor's throughput. Tests where the link was
+less saturated than expected are flagged, so a low harm can be told
+apart from a competitor that never filled the link.
+
+If a list of offered loads is configured, steps 1-3 are repeated for
+each load, and the results show FCT and harm vs load for each CCA.`
 
 // SetTestMode changes defaults to be suitable for a quick test.
 func SetTestMode() {
 	RTT = []metric.Duration{metric.Ms(10), metric.Ms(20)}
 	FCTDur = 5 * time.Second
 	SlowStartDelay = 0
+	ProbeDur = 2 * time.Second
 }
 
 // DelayQdisc returns the qdisc used to simulate delay.
@@ -122,18 +299,97 @@ const SoloID = "-"
 
 // Result is one test result.
 type Result struct {
-	RTT metric.Duration
-	CCA string
+	RTT            metric.Duration
+	Load           float64
+	CCA            string
+	Timing         ccafct.TimingStats
+	Transport      ccafct.TransportStats
+	ByCCA          ccafct.CCAStats
+	Competitor     ccafct.BulkStats
+	CompetitorSolo ccafct.BulkStats
+	Probe          ccafct.ProbeStats
+	ProbeIdle      ccafct.ProbeStats
+	QdiscDown      QdiscSummary
+	QdiscUp        QdiscSummary
+	Utilization    Utilization
 	ccafct.Stats
 }
 
+// sweep returns true if offered loads are being swept.
+func sweep() bool {
+	return len(FCTLoads) > 0
+}
+
+// loads returns the offered loads to test. A load of zero means FCTMeanArrival
+// is used instead.
+func loads() []float64 {
+	if sweep() {
+		return FCTLoads
+	}
+	return []float64{FCTLoad}
+}
+
+// loadString returns an offered load as a percentage.
+func loadString(load float64) string {
+	return pretty.Float64(load*100, 1) + "%"
+}
+
+// setTraceDur sets FCTDur to the span of the replayed trace, if any, so the
+// test and competitor timeouts cover the whole trace.
+func setTraceDur() (err error) {
+	if FCTReplayTrace == "" {
+		return
+	}
+	var tr []ccafct.Arrival
+	if tr, err = ccafct.ReadTraceFile(FCTReplayTrace); err != nil {
+		return
+	}
+	d := tr[len(tr)-1].Offset
+	if d <= 0 {
+		return fmt.Errorf("%s: trace spans no time", FCTReplayTrace)
+	}
+	FCTDur = d
+	return
+}
+
+// fctParams returns the FCT workload parameters for the given server address
+// and offered load.
+func fctParams(addr string, load float64) ccafct.Params {
+	return ccafct.Params{
+		Addr:           addr,
+		CCA:            FCTCCA,
+		CCAMix:         FCTCCAMix,
+		Protocol:       FCTProtocol,
+		Direction:      FCTDirection,
+		ServerSockOpts: SockOptProfiles[FCTProfile].Server,
+		ClientSockOpts: SockOptProfiles[FCTProfile].Client,
+		Duration:       FCTDur,
+		MeanArrival:    FCTMeanArrival,
+		Bottleneck:     Bandwidth,
+		Load:           load,
+		AllowOverload:  FCTAllowOverload,
+		Loop:           FCTLoop,
+		Concurrency:    FCTConcurrency,
+		ThinkTime:      FCTThinkTime,
+		MaxOutstanding: FCTMaxOutstanding,
+		Arrival:        FCTArrival,
+		LenModel:       FCTLenModel,
+		LenCDFFile:     FCTLenCDFFile,
+		LenP5:          FCTLenP5,
+		LenP95:         FCTLenP95,
+		Seed:           FCTSeed,
+		RecordTrace:    FCTRecordTrace,
+		ReplayTrace:    FCTReplayTrace,
+	}
+}
+
 // setupRig sets up the netns test rig.
 func setupRig(rtt metric.Duration) (rig *netns.Rig, err error) {
-	// set up 2+2+2 rig
+	// set up 3+2+3 rig, with the third endpoint pair for the latency probe
 	rig = &netns.Rig{
-		LeftEndpoints:  2,
+		LeftEndpoints:  3,
 		Middleboxes:    2,
-		RightEndpoints: 2,
+		RightEndpoints: 3,
 	}
 	defer func() {
 		if err != nil {
@@ -179,25 +435,57 @@ func setupRig(rtt metric.Duration) (rig *netns.Rig, err error) {
 	spec := executor.Spec{Log: true}
 	ex.RunSpecf(spec, "ip netns exec %s ping -c 2 -i 0.1 %s", l0, rig.RightIP(0))
 	ex.RunSpecf(spec, "ip netns exec %s ping -c 2 -i 0.1 %s", l1, rig.RightIP(1))
+	ex.RunSpecf(spec, "ip netns exec %s ping -c 2 -i 0.1 %s", rig.LeftNs(2),
+		rig.RightIP(2))
 
 	err = ex.Err()
 
 	return
 }
 
-// runTest runs a test.
-func runTest(rig *netns.Rig, testJSON []byte, cca string) (data ccafct.Data, err error) {
+// runTest runs a test, and returns the FCT data, the competitor's samples, the
+// latency probe's samples, the bottleneck qdisc samples and the bottleneck
+// link usage while the FCT workload ran.
+func runTest(rig *netns.Rig, testJSON []byte, cca string) (data ccafct.Data,
+	comp []ccafct.BulkSample, probe []ccafct.ProbeSample,
+	qdisc []QdiscSample, link linkUsage, err error) {
 	ex := new(executor.Executor)
 
+	qctx, qcancel := context.WithCancel(context.Background())
+	qdone := make(chan struct{})
+	go func() {
+		defer close(qdone)
+		qdisc = sampleQdiscs(qctx, rig)
+	}()
+	defer func() {
+		qcancel()
+		<-qdone
+	}()
+
+	var probeJSON []byte
+	if probeJSON, err = latencyProbeJSON(rig, 0); err != nil {
+		return
+	}
+	probeJob := ex.RunSpecf(executor.Spec{
+		Stdin:      probeJSON,
+		Background: true,
+		LogStderr:  true,
+	}, "ip netns exec %s ./fct probe json", rig.LeftNs(2))
+
+	var bulkJob *executor.Job
 	if cca != SoloID {
-		t := SlowStartDelay + FCTDur + FCTTimeout
+		var bulkJSON []byte
+		if bulkJSON, err = competitorJSON(rig, cca,
+			SlowStartDelay+FCTDur+FCTTimeout); err != nil {
+			return
+		}
 		spec := executor.Spec{
-			Background:   true,
-			Log:          true,
-			IgnoreErrors: true,
+			Stdin:      bulkJSON,
+			Background: true,
+			LogStderr:  true,
 		}
-		ex.RunSpecf(spec, "ip netns exec %s iperf3 -R -C %s -t %d -c %s",
-			rig.LeftNs(0), cca, int(t.Seconds()), rig.RightIP(0))
+		bulkJob = ex.RunSpecf(spec, "ip netns exec %s ./fct bulk json",
+			rig.LeftNs(0))
 		time.Sleep(SlowStartDelay)
 	}
 
@@ -205,22 +493,154 @@ func runTest(rig *netns.Rig, testJSON []byte, cca string) (data ccafct.Data, err
 		FCTDur+FCTTimeout+ContextTimeout)
 	defer cancel()
 
+	before, berr := readLinks(rig)
 	testJob := ex.RunSpecf(executor.Spec{
 		Stdin:   testJSON,
 		Context: ctx,
 	}, "ip netns exec %s ./fct json", rig.LeftNs(1))
+	after, aerr := readLinks(rig)
 
 	ex.Interrupt()
 	ex.Wait()
 	if err = ex.Err(); err != nil {
 		return
 	}
+	if berr != nil {
+		err = berr
+		return
+	}
+	if aerr != nil {
+		err = aerr
+		return
+	}
+	link = after.usage(before)
 
 	// unmarshal data and get stats
 	if json.Unmarshal(testJob.Stdout.Bytes(), &data); err != nil {
 		return
 	}
+	if data.Dropped > 0 {
+		log.Printf("warning: %d flows dropped at max outstanding",
+			data.Dropped)
+	}
+	if bulkJob != nil {
+		if comp, err = ccafct.ReadBulkSamples(&bulkJob.Stdout); err != nil {
+			return
+		}
+	}
+	if probe, err = ccafct.ReadProbeSamples(&probeJob.Stdout); err != nil {
+		return
+	}
+
+	return
+}
 
+// latencyProbeJSON returns the latency probe parameters in JSON, for fct probe
+// json. The probe runs until it's interrupted, or the given duration elapses,
+// if not zero.
+func latencyProbeJSON(rig *netns.Rig, dur time.Duration) ([]byte, error) {
+	return json.Marshal(ccafct.Probe{
+		Addr:     rig.RightIP(2),
+		Interval: ProbeInterval,
+		Size:     ProbeSize,
+		Duration: dur,
+	})
+}
+
+// runProbe runs the latency probe alone for ProbeDur, and returns its stats,
+// as the baseline for RTT harm.
+func runProbe(rig *netns.Rig) (stats ccafct.ProbeStats, err error) {
+	var probeJSON []byte
+	if probeJSON, err = latencyProbeJSON(rig, ProbeDur); err != nil {
+		return
+	}
+	ex := new(executor.Executor)
+	job := ex.RunSpecf(executor.Spec{
+		Stdin:     probeJSON,
+		LogStderr: true,
+	}, "ip netns exec %s ./fct probe json", rig.LeftNs(2))
+	if err = ex.Err(); err != nil {
+		return
+	}
+	var samples []ccafct.ProbeSample
+	if samples, err = ccafct.ReadProbeSamples(&job.Stdout); err != nil {
+		return
+	}
+	stats = ccafct.AnalyzeProbe(samples)
+	return
+}
+
+// serverArgs returns the fct server arguments for FCTZeroCopy and
+// FCTServerCPU.
+func serverArgs() (a string) {
+	if FCTZeroCopy {
+		a += " -zerocopy"
+	}
+	if FCTServerCPU {
+		a += " -cpu"
+	}
+	return
+}
+
+// checkCompetitor returns an error if a competitor spec is invalid, or if any
+// of its flows would start at or after dur, the time from the competitor's
+// start to the end of the FCT workload, so they would never run.
+func checkCompetitor(cca string, dur time.Duration) error {
+	spec, err := ccafct.ParseBulkSpec(cca)
+	if err != nil {
+		return err
+	}
+	if l := spec.LastStart(); l >= dur {
+		return fmt.Errorf("last flow starts at %s, but the competitor only "+
+			"runs for %s (SlowStartDelay+FCTDur)", l, dur)
+	}
+	return nil
+}
+
+// competitorJSON returns the bulk flow parameters for a competitor in JSON,
+// for fct bulk json. The competitor runs until it's interrupted, or the given
+// duration elapses.
+func competitorJSON(rig *netns.Rig, cca string, dur time.Duration) (
+	bulkJSON []byte, err error) {
+	var spec ccafct.BulkSpec
+	if spec, err = ccafct.ParseBulkSpec(cca); err != nil {
+		return
+	}
+	bulkJSON, err = json.Marshal(ccafct.Bulk{
+		Addr:           rig.RightIP(0),
+		Spec:           spec,
+		ServerSockOpts: SockOptProfiles[CompetitorProfile].Server,
+		ClientSockOpts: SockOptProfiles[CompetitorProfile].Client,
+		Duration:       dur,
+		Interval:       CompetitorInterval,
+	})
+	return
+}
+
+// runCompetitor runs a competitor alone for SlowStartDelay plus FCTDur, and
+// returns its stats after SlowStartDelay, as the baseline for competitor harm.
+func runCompetitor(rig *netns.Rig, cca string) (stats ccafct.BulkStats,
+	err error) {
+	var bulkJSON []byte
+	if bulkJSON, err = competitorJSON(rig, cca,
+		SlowStartDelay+FCTDur); err != nil {
+		return
+	}
+	ex := new(executor.Executor)
+	start := time.Now()
+	job := ex.RunSpecf(executor.Spec{
+		Stdin:     bulkJSON,
+		LogStderr: true,
+	}, "ip netns exec %s ./fct bulk json", rig.LeftNs(0))
+	if err = ex.Err(); err != nil {
+		return
+	}
+	var comp []ccafct.BulkSample
+	if comp, err = ccafct.ReadBulkSamples(&job.Stdout); err != nil {
+		return
+	}
+	stats = ccafct.AnalyzeBulk(ccafct.BulkSamplesBetween(comp,
+		start.Add(SlowStartDelay), time.Now()))
 	return
 }
 
@@ -235,62 +655,218 @@ func runRTT(rtt metric.Duration) (result []Result, err error) {
 		rig.Teardown()
 	}()
 
-	// create test
-	test := ccafct.NewTest(ccafct.Params{
-		Addr:        rig.RightIP(1),
-		CCA:         FCTCCA,
-		Duration:    FCTDur,
-		MeanArrival: FCTMeanArrival,
-		LenP5:       FCTLenP5,
-		LenP95:      FCTLenP95,
-	})
-
 	// start servers
 	ex := new(executor.Executor)
 	defer ex.Kill()
 	r0 := rig.RightNs(0)
 	r1 := rig.RightNs(1)
+	r2 := rig.RightNs(2)
+	ex.RunSpecf(executor.Spec{Background: true, NoWait: true},
+		"ip netns exec %s ./fct bulk server", r0)
 	ex.RunSpecf(executor.Spec{Background: true, NoWait: true},
-		"ip netns exec %s iperf3 -s", r0)
+		"ip netns exec %s ./fct server%s", r1, serverArgs())
 	ex.RunSpecf(executor.Spec{Background: true, NoWait: true},
-		"ip netns exec %s ./fct server", r1)
+		"ip netns exec %s ./fct probe server", r2)
 	time.Sleep(200 * time.Millisecond)
 
+	// run the latency probe alone, for the RTT harm baseline
+	log.Printf("running %s latency probe alone", rtt)
+	var probeIdle ccafct.ProbeStats
+	if probeIdle, err = runProbe(rig); err != nil {
+		return
+	}
+
+	// run each competitor alone, for the competitor harm baseline
+	compSolo := make(map[string]ccafct.BulkStats)
+	for _, cca := range CCA {
+		log.Printf("running %s %s alone", rtt, cca)
+		if compSolo[cca], err = runCompetitor(rig, cca); err != nil {
+			return
+		}
+	}
+
+	// run each offered load
+	for _, load := range loads() {
+		var res []Result
+		if res, err = runLoad(rig, rtt, load, compSolo,
+			probeIdle); err != nil {
+			return
+		}
+		result = append(result, res...)
+	}
+
+	return
+}
+
+// runLoad runs one offered load across the CC algos. compSolo contains the
+// stats for each competitor alone, and probeIdle the stats for the latency
+// probe alone.
+func runLoad(rig *netns.Rig, rtt metric.Duration, load float64,
+	compSolo map[string]ccafct.BulkStats, probeIdle ccafct.ProbeStats) (
+	result []Result, err error) {
+	// create test
+	var test ccafct.Test
+	if test, err = ccafct.NewTest(fctParams(rig.RightIP(1), load)); err != nil {
+		return
+	}
+
 	// create test JSON
 	var testJSON []byte
-	if testJSON, err = json.Marshal(test); err != nil {
+	if testJSON, err = json.Marshal(&test); err != nil {
 		return
 	}
 
+	desc := rtt.String()
+	if sweep() {
+		desc = fmt.Sprintf("%s %s load", rtt, loadString(load))
+	}
+
 	// solo test
-	log.Printf("running %s solo", rtt)
+	log.Printf("running %s solo", desc)
 	var data ccafct.Data
-	if data, err = runTest(rig, testJSON, SoloID); err != nil {
+	var probe []ccafct.ProbeSample
+	var qdisc []QdiscSample
+	var link linkUsage
+	if data, _, probe, qdisc, link, err = runTest(rig, testJSON,
+		SoloID); err != nil {
 		return
 	}
 	var solo ccafct.Stats
-	if solo, err = ccafct.Analyze(data); err != nil {
+	if solo, err = ccafct.Analyze(&data, FCTStats); err != nil {
 		return
 	}
-	result = append(result, Result{rtt, SoloID, solo})
+	var soloTiming ccafct.TimingStats
+	if soloTiming, err = ccafct.AnalyzeTiming(&data, TimingStats); err != nil {
+		return
+	}
+	var soloCCA ccafct.CCAStats
+	if soloCCA, err = ccafct.AnalyzeCCA(&data, FCTStats); err != nil {
+		return
+	}
+	soloProbe := analyzeProbe(probe, &data, probeIdle)
+	var down, up QdiscSummary
+	if down, up, err = summarizeQdiscs(qdisc, &data, rtt, load,
+		SoloID); err != nil {
+		return
+	}
+	result = append(result, Result{rtt, load, SoloID, soloTiming,
+		ccafct.AnalyzeTransport(&data), soloCCA, ccafct.BulkStats{},
+		ccafct.BulkStats{}, soloProbe, probeIdle, down, up,
+		utilization(link, &test, &data, nil), solo})
 
 	// CCA tests
 	for _, cca := range CCA {
-		log.Printf("running %s %s", rtt, cca)
-		if data, err = runTest(rig, testJSON, cca); err != nil {
+		log.Printf("running %s %s", desc, cca)
+		var comp []ccafct.BulkSample
+		if data, comp, probe, qdisc, link, err = runTest(rig, testJSON,
+			cca); err != nil {
 			return
 		}
 		var stats ccafct.Stats
-		if stats, err = ccafct.Analyze(data); err != nil {
+		if stats, err = ccafct.Analyze(&data, FCTStats); err != nil {
 			return
 		}
 		stats.SetHarm(solo)
-		result = append(result, Result{rtt, cca, stats})
+		var timing ccafct.TimingStats
+		if timing, err = ccafct.AnalyzeTiming(&data, TimingStats); err != nil {
+			return
+		}
+		timing.SetHarm(soloTiming)
+		var byCCA ccafct.CCAStats
+		if byCCA, err = ccafct.AnalyzeCCA(&data, FCTStats); err != nil {
+			return
+		}
+		byCCA.SetHarm(soloCCA)
+		compStats := ccafct.AnalyzeBulk(ccafct.BulkSamplesBetween(comp,
+			data.Start, data.End))
+		compStats.SetHarm(compSolo[cca])
+		if down, up, err = summarizeQdiscs(qdisc, &data, rtt, load,
+			cca); err != nil {
+			return
+		}
+		result = append(result, Result{rtt, load, cca, timing,
+			ccafct.AnalyzeTransport(&data), byCCA, compStats, compSolo[cca],
+			analyzeProbe(probe, &data, probeIdle), probeIdle, down, up,
+			utilization(link, &test, &data, comp), stats})
+	}
+
+	return
+}
+
+// summarizeQdiscs returns the qdisc summaries for the download and upload
+// bottlenecks while the FCT workload ran, and appends the samples to QdiscLog,
+// if set.
+func summarizeQdiscs(qdisc []QdiscSample, data *ccafct.Data,
+	rtt metric.Duration, load float64, cca string) (down, up QdiscSummary,
+	err error) {
+	down = summarizeQdisc(qdiscSamplesBetween(qdisc, BottleneckDown,
+		data.Start, data.End))
+	up = summarizeQdisc(qdiscSamplesBetween(qdisc, BottleneckUp, data.Start,
+		data.End))
+	if QdiscLog == "" {
+		return
+	}
+	var f *os.File
+	if f, err = os.OpenFile(QdiscLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+		0644); err != nil {
+		return
+	}
+	defer func() {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	enc := json.NewEncoder(f)
+	for _, q := range qdisc {
+		if err = enc.Encode(struct {
+			RTT  metric.Duration
+			Load float64
+			CCA  string
+			QdiscSample
+		}{rtt, load, cca, q}); err != nil {
+			return
+		}
 	}
+	return
+}
 
+// analyzeProbe returns the stats for the latency probe samples sent while the
+// FCT workload ran, with RTT harm relative to the probe alone.
+func analyzeProbe(probe []ccafct.ProbeSample, data *ccafct.Data,
+	idle ccafct.ProbeStats) (stats ccafct.ProbeStats) {
+	stats = ccafct.AnalyzeProbe(ccafct.ProbeSamplesBetween(probe, data.Start,
+		data.End))
+	stats.SetHarm(idle)
 	return
 }
 
+// sortByLoad sorts results by RTT, then CCA, then offered load, so the results
+// for each CCA form an FCT vs load curve.
+func sortByLoad(result []Result) {
+	rttIdx := make(map[metric.Duration]int)
+	for i, r := range RTT {
+		rttIdx[r] = i
+	}
+	ccaIdx := map[string]int{SoloID: 0}
+	for i, c := range CCA {
+		ccaIdx[c] = i + 1
+	}
+	loadIdx := make(map[float64]int)
+	for i, l := range FCTLoads {
+		loadIdx[l] = i
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if rttIdx[a.RTT] != rttIdx[b.RTT] {
+			return rttIdx[a.RTT] < rttIdx[b.RTT]
+		}
+		if ccaIdx[a.CCA] != ccaIdx[b.CCA] {
+			return ccaIdx[a.CCA] < ccaIdx[b.CCA]
+		}
+		return loadIdx[a.Load] < loadIdx[b.Load]
+	})
+}
+
 // run runs the test.
 func run() (err error) {
 	pretty.UnderlineDouble(os.Stdout,
@@ -299,22 +875,52 @@ func run() (err error) {
 	fmt.Printf("%s\n", Description)
 	fmt.Println()
 
+	// emit config file
+	if ConfigFile != "" {
+		pretty.Underline(os.Stdout, "Configuration (%s):", ConfigFile)
+		os.Stdout.Write(ConfigText)
+		if len(ConfigText) > 0 && ConfigText[len(ConfigText)-1] != '\n' {
+			fmt.Println()
+		}
+		fmt.Println()
+	}
+
 	// emit test config
 	pretty.Underline(os.Stdout, "Test Parameters:")
 	tw := pretty.NewTableWriter(os.Stdout)
 	tw.Row("CCAs under test:", strings.Join(CCA, ", "))
 	tw.Printf("RTTs:\t%s", metric.JoinDuration(RTT, ", "))
 	tw.Row("Bandwidth:", Bandwidth)
+	if sweep() {
+		l := make([]string, len(FCTLoads))
+		for i, v := range FCTLoads {
+			l[i] = loadString(v)
+		}
+		tw.Row("Offered loads:", strings.Join(l, ", "))
+	}
 	tw.Row("Qdisc:", Qdisc)
+	if FCTZeroCopy {
+		tw.Row("FCT server:", "zero-copy")
+	}
+	if CompetitorProfile != "" {
+		p := SockOptProfiles[CompetitorProfile]
+		tw.Row("Competitor options:", fmt.Sprintf(
+			"%s (server: %s, client: %s)", CompetitorProfile, p.Server,
+			p.Client))
+	}
 	tw.Row("Slow start delay:", SlowStartDelay)
+	tw.Row("Latency probe:", fmt.Sprintf("%d bytes every %s", ProbeSize,
+		ProbeInterval))
 	tw.Flush()
 
 	// create sample FCT test and emit config
 	fmt.Println()
 	pretty.Underline(os.Stdout, "FCT Workload Parameters:")
-	ccafct.NewTest(ccafct.Params{
-		Duration: FCTDur,
-	}).Emit(os.Stdout)
+	var test ccafct.Test
+	if test, err = ccafct.NewTest(fctParams("", loads()[0])); err != nil {
+		return
+	}
+	test.Emit(os.Stdout)
 
 	// run each RTT and add results
 	var result []Result
@@ -327,17 +933,145 @@ func run() (err error) {
 	}
 
 	// emit results
+	if sweep() {
+		sortByLoad(result)
+	}
 	fmt.Println()
-	tw = pretty.NewTableWriterPad(os.Stdout, 2, "")
-	tw.URow("RTT", "CCA", "GeoMean (Harm)", "Median (Harm)", "P95 (Harm)")
-	for _, r := range result {
-		tw.Row(r.RTT, r.CCA, r.GeoMean, r.Median, r.P95)
+	emitResults(result, append(FCTStats.Header(" (Harm)"),
+		"Competitor Tput (Harm)"), func(r Result) []interface{} {
+		return append(r.Stats.Row(), bulkThroughput(r.Competitor))
+	})
+	for _, c := range mixedCCAs(result) {
+		c := c
+		fmt.Println()
+		pretty.Underline(os.Stdout, "FCT for %s Flows:", c)
+		emitResults(result, FCTStats.Header(" (Harm)"),
+			func(r Result) []interface{} {
+				s, ok := r.ByCCA[c]
+				if !ok {
+					return dashes(len(FCTStats))
+				}
+				return s.Row()
+			})
 	}
-	tw.Flush()
+	fmt.Println()
+	pretty.Underline(os.Stdout, "Flow Timing Breakdown:")
+	var timingHeader []string
+	for _, p := range []string{"Handshake", "TTFB", "Transfer"} {
+		for _, h := range TimingStats.Header(" (Harm)") {
+			timingHeader = append(timingHeader, p+" "+h)
+		}
+	}
+	emitResults(result, timingHeader, func(r Result) []interface{} {
+		t := r.Timing
+		row := append(t.Handshake.Row(), t.TTFB.Row()...)
+		return append(row, t.Transfer.Row()...)
+	})
+	fmt.Println()
+	pretty.Underline(os.Stdout, "Transport (Sender TCP_INFO):")
+	emitResults(result, ccafct.TransportHeader, func(r Result) []interface{} {
+		return r.Transport.Row()
+	})
+	fmt.Println()
+	pretty.Underline(os.Stdout, "Competitor (Sender TCP_INFO):")
+	emitResults(result, []string{"Flows", "Alone Tput", "Tput (Harm)",
+		"Retransmits", "Median SRTT"}, func(r Result) []interface{} {
+		c := r.Competitor.Row()
+		return []interface{}{c[0], bulkThroughput(r.CompetitorSolo), c[1],
+			c[2], c[3]}
+	})
+	fmt.Println()
+	pretty.Underline(os.Stdout, "Latency Probe (UDP RTT):")
+	emitResults(result, append([]string{"Idle Median"},
+		ccafct.ProbeHeader...), func(r Result) []interface{} {
+		idle := interface{}("-")
+		if r.ProbeIdle.Sent > 0 {
+			idle = r.ProbeIdle.Median
+		}
+		return append([]interface{}{idle}, r.Probe.Row()...)
+	})
+	fmt.Println()
+	utilDir := "Download"
+	if fctBottleneck() == BottleneckUp {
+		utilDir = "Upload"
+	}
+	pretty.Underline(os.Stdout, "Bottleneck Utilization (%s):", utilDir)
+	emitResults(result, UtilizationHeader, func(r Result) []interface{} {
+		return r.Utilization.Row()
+	})
+	fmt.Println()
+	pretty.Underline(os.Stdout, "Bottleneck Qdisc (Download):")
+	emitResults(result, QdiscHeader, func(r Result) []interface{} {
+		return r.QdiscDown.Row()
+	})
+	fmt.Println()
+	pretty.Underline(os.Stdout, "Bottleneck Qdisc (Upload):")
+	emitResults(result, QdiscHeader, func(r Result) []interface{} {
+		return r.QdiscUp.Row()
+	})
 
 	return
 }
 
+// dashes returns n columns of "-", for results without stats.
+func dashes(n int) (d []interface{}) {
+	for i := 0; i < n; i++ {
+		d = append(d, "-")
+	}
+	return
+}
+
+// mixedCCAs returns the sorted CCAs in the results for runs whose flows used
+// more than one CCA, whether from FCTCCAMix or a replayed trace.
+func mixedCCAs(result []Result) (ccas []string) {
+	seen := make(map[string]bool)
+	for _, r := range result {
+		if len(r.ByCCA) < 2 {
+			continue
+		}
+		for c := range r.ByCCA {
+			if !seen[c] {
+				seen[c] = true
+				ccas = append(ccas, c)
+			}
+		}
+	}
+	sort.Strings(ccas)
+	return
+}
+
+// bulkThroughput returns the throughput from bulk stats, or "-" if there were
+// no flows.
+func bulkThroughput(s ccafct.BulkStats) interface{} {
+	if s.Flows == 0 {
+		return "-"
+	}
+	return s.Throughput
+}
+
+// emitResults emits a table of results, with the RTT, CCA and offered load (if
+// sweeping) followed by the given columns.
+func emitResults(result []Result, header []string,
+	cols func(Result) []interface{}) {
+	tw := pretty.NewTableWriterPad(os.Stdout, 2, "")
+	h := []interface{}{"RTT", "CCA"}
+	if sweep() {
+		h = append(h, "Load")
+	}
+	for _, c := range header {
+		h = append(h, c)
+	}
+	tw.URow(h...)
+	for _, r := range result {
+		row := []interface{}{r.RTT, r.CCA}
+		if sweep() {
+			row = append(row, loadString(r.Load))
+		}
+		tw.Row(append(row, cols(r)...)...)
+	}
+	tw.Flush()
+}
+
 // main entry point.
 func main() {
 	log.SetFlags(0)
@@ -347,6 +1081,7 @@ func main() {
 	// process flags
 	var cca string
 	var testMode bool
+	var configFile string
 	flag.Usage = func() {
 		w := flag.CommandLine.Output()
 		fmt.Fprintf(w, "usage: %s [options]\n", os.Args[0])
@@ -359,15 +1094,47 @@ func main() {
 		fmt.Fprintf(w, "%s\n", Description)
 	}
 	flag.StringVar(&cca, "cca", DefaultCompetitionCCA,
-		"comma separated list of CCAs to test for the competition flow")
+		"comma separated list of competitor CCAs or flow specs, "+
+			"e.g. cubic,4xbbr:every=2s")
 	flag.BoolVar(&testMode, "t", false, "perform quick test to verify setup")
+	flag.StringVar(&configFile, "config", "",
+		"JSON config file with experiment parameters")
 	flag.Parse()
-	for _, c := range strings.Split(cca, ",") {
-		CCA = append(CCA, strings.TrimSpace(c))
+	if configFile != "" {
+		cfg, raw, err := loadConfig(configFile)
+		if err != nil {
+			log.Fatalf("ERROR: invalid config:\n%s", err)
+		}
+		cfg.apply()
+		ConfigFile = configFile
+		ConfigText = raw
+	}
+	ccaSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "cca" {
+			ccaSet = true
+		}
+	})
+	if ccaSet || len(CCA) == 0 {
+		CCA = CCA[:0]
+		for _, c := range strings.Split(cca, ",") {
+			CCA = append(CCA, strings.TrimSpace(c))
+		}
 	}
 	if testMode {
 		SetTestMode()
 	}
+	if err := setTraceDur(); err != nil {
+		log.Fatalf("ERROR: unable to read replayed trace: %s", err)
+	}
+	for _, c := range CCA {
+		if err := checkCompetitor(c, SlowStartDelay+FCTDur); err != nil {
+			log.Fatalf("ERROR: invalid competitor '%s': %s", c, err)
+		}
+	}
+	if FCTSeed == 0 {
+		FCTSeed = ccafct.NewSeed()
+	}
 
 	// run the test
 	if err := run(); err != nil {
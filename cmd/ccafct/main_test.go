@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	ccafct "github.com/heistp/fct"
+	"github.com/heistp/fct/metric"
+)
+
+func TestSortByLoad(t *testing.T) {
+	defer func(rtt []metric.Duration, cca []string, loads []float64) {
+		RTT, CCA, FCTLoads = rtt, cca, loads
+	}(RTT, CCA, FCTLoads)
+	RTT = []metric.Duration{metric.Ms(20), metric.Ms(10)}
+	CCA = []string{"cubic", "bbr"}
+	FCTLoads = []float64{0.5, 0.1}
+
+	var result []Result
+	for _, l := range FCTLoads {
+		for _, r := range []metric.Duration{metric.Ms(10), metric.Ms(20)} {
+			for _, c := range []string{"bbr", SoloID, "cubic"} {
+				result = append(result, Result{RTT: r, Load: l, CCA: c})
+			}
+		}
+	}
+	sortByLoad(result)
+
+	var got []Result
+	for _, r := range RTT {
+		for _, c := range append([]string{SoloID}, CCA...) {
+			for _, l := range FCTLoads {
+				got = append(got, Result{RTT: r, Load: l, CCA: c})
+			}
+		}
+	}
+	if !reflect.DeepEqual(result, got) {
+		t.Errorf("sorted %v, want %v", result, got)
+	}
+}
+
+func TestMixedCCAs(t *testing.T) {
+	result := []Result{
+		{CCA: SoloID, ByCCA: ccafct.CCAStats{"cubic": {}}},
+		{CCA: "cubic", ByCCA: ccafct.CCAStats{"reno": {}, "bbr": {}}},
+		{CCA: "bbr", ByCCA: ccafct.CCAStats{"cubic": {}, "bbr": {}}},
+	}
+	want := []string{"bbr", "cubic", "reno"}
+	if c := mixedCCAs(result); !reflect.DeepEqual(c, want) {
+		t.Errorf("mixed CCAs %v, want %v", c, want)
+	}
+	if c := mixedCCAs(result[:1]); len(c) != 0 {
+		t.Errorf("mixed CCAs %v for a single CCA, want none", c)
+	}
+}
+
+func TestSetTraceDur(t *testing.T) {
+	defer func(dur time.Duration, trace string) {
+		FCTDur, FCTReplayTrace = dur, trace
+	}(FCTDur, FCTReplayTrace)
+	FCTDur = time.Minute
+	FCTReplayTrace = filepath.Join(t.TempDir(), "trace.csv")
+	if err := os.WriteFile(FCTReplayTrace, []byte("10,1000\n100,2000\n"),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setTraceDur(); err != nil {
+		t.Fatal(err)
+	}
+	if FCTDur != 90*time.Second {
+		t.Errorf("FCTDur %s, want the trace's span of 1m30s", FCTDur)
+	}
+
+	if err := os.WriteFile(FCTReplayTrace, []byte("10,1000\n"),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setTraceDur(); err == nil {
+		t.Error("setTraceDur accepted a trace that spans no time")
+	}
+}
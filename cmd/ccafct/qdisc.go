@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/heistp/fct/netns"
+	"github.com/heistp/fct/pretty"
+)
+
+// Bottleneck directions.
+const (
+	// BottleneckDown is the bottleneck for traffic from right to left.
+	BottleneckDown = "download"
+
+	// BottleneckUp is the bottleneck for traffic from left to right.
+	BottleneckUp = "upload"
+)
+
+// bottleneck is one of the HTB qdiscs added by setupRig.
+type bottleneck struct {
+	dir string
+	ns  string
+	dev string
+}
+
+// bottlenecks returns the bottlenecks added by setupRig.
+func bottlenecks(rig *netns.Rig) []bottleneck {
+	m0 := rig.MidNs(0)
+	m1 := rig.MidNs(1)
+	return []bottleneck{
+		{BottleneckDown, m0, rig.LeftDev(m0)},
+		{BottleneckUp, m1, rig.RightDev(m1)},
+	}
+}
+
+// QdiscSample is one sample of the stats for the qdiscs on a bottleneck.
+type QdiscSample struct {
+	// Time is when the sample was taken.
+	Time time.Time
+
+	// Dir is the bottleneck direction, BottleneckDown or BottleneckUp.
+	Dir string
+
+	// Qdisc contains the stats for each qdisc on the bottleneck's device.
+	Qdisc []netns.QdiscStats
+}
+
+// sampleQdiscs samples the bottleneck qdisc stats every QdiscInterval until
+// ctx is done, and returns the samples. Errors are logged, and the samples
+// that failed are skipped.
+func sampleQdiscs(ctx context.Context, rig *netns.Rig) (
+	samples []QdiscSample) {
+	bn := bottlenecks(rig)
+	t := time.NewTicker(QdiscInterval)
+	defer t.Stop()
+	for {
+		for _, b := range bn {
+			now := time.Now()
+			q, err := rig.QdiscStats(b.ns, b.dev)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("unable to sample qdisc stats: %s", err)
+				}
+				continue
+			}
+			samples = append(samples, QdiscSample{now, b.dir, q})
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// qdiscSamplesBetween returns the samples for the given bottleneck direction
+// taken no earlier than start, and no later than end.
+func qdiscSamplesBetween(samples []QdiscSample, dir string, start,
+	end time.Time) (between []QdiscSample) {
+	for _, s := range samples {
+		if s.Dir == dir && !s.Time.Before(start) && !s.Time.After(end) {
+			between = append(between, s)
+		}
+	}
+	return
+}
+
+// QdiscSummary summarizes the qdisc samples for a bottleneck during one test.
+// The queue, drop, mark and flow stats come from the AQM qdisc under HTB, and
+// overlimits from HTB itself, where they count the times the link was full.
+type QdiscSummary struct {
+	// Samples is the number of samples.
+	Samples int
+
+	// Kind is the AQM qdisc kind.
+	Kind string
+
+	// MeanBacklog is the mean backlog, in bytes.
+	MeanBacklog float64
+
+	// MaxBacklog is the maximum backlog, in bytes.
+	MaxBacklog uint64
+
+	// MeanQlen is the mean queue length, in packets.
+	MeanQlen float64
+
+	// Drops is the number of packets dropped.
+	Drops uint64
+
+	// ECNMarks is the number of packets ECN marked.
+	ECNMarks uint64
+
+	// Overlimits is the number of HTB overlimits.
+	Overlimits uint64
+
+	// MaxFlows is the maximum number of active flow queues, if FlowQueues is
+	// true.
+	MaxFlows uint64
+
+	// FlowQueues is true if the AQM reports flow queue stats.
+	FlowQueues bool
+}
+
+// aqmQdisc returns the stats for the AQM qdisc under HTB, and the HTB qdisc.
+// If there's no AQM qdisc, the HTB qdisc stats are returned for both.
+func aqmQdisc(q []netns.QdiscStats) (aqm, htb netns.QdiscStats) {
+	for _, s := range q {
+		if s.Parent == "" {
+			htb = s
+			aqm = s
+			break
+		}
+	}
+	for _, s := range q {
+		if s.Parent == "1:1" {
+			aqm = s
+			break
+		}
+	}
+	return
+}
+
+// summarizeQdisc summarizes the qdisc samples for one bottleneck. Counters are
+// the difference between the first and last samples.
+func summarizeQdisc(samples []QdiscSample) (sum QdiscSummary) {
+	sum.Samples = len(samples)
+	if sum.Samples == 0 {
+		return
+	}
+	var backlog, qlen float64
+	for _, s := range samples {
+		a, _ := aqmQdisc(s.Qdisc)
+		backlog += float64(a.Backlog)
+		qlen += float64(a.Qlen)
+		if a.Backlog > sum.MaxBacklog {
+			sum.MaxBacklog = a.Backlog
+		}
+		if a.Flows > sum.MaxFlows {
+			sum.MaxFlows = a.Flows
+		}
+		sum.FlowQueues = sum.FlowQueues || a.FlowQueues
+	}
+	sum.MeanBacklog = backlog / float64(sum.Samples)
+	sum.MeanQlen = qlen / float64(sum.Samples)
+	a0, h0 := aqmQdisc(samples[0].Qdisc)
+	a1, h1 := aqmQdisc(samples[len(samples)-1].Qdisc)
+	sum.Kind = a1.Kind
+	sum.Drops = a1.Drops - a0.Drops
+	sum.ECNMarks = a1.ECNMarks - a0.ECNMarks
+	sum.Overlimits = h1.Overlimits - h0.Overlimits
+	return
+}
+
+// Row returns the qdisc summary as table columns.
+func (s QdiscSummary) Row() []interface{} {
+	if s.Samples == 0 {
+		return []interface{}{"-", "-", "-", "-", "-", "-", "-"}
+	}
+	flows := "-"
+	if s.FlowQueues {
+		flows = strconv.FormatUint(s.MaxFlows, 10)
+	}
+	return []interface{}{
+		pretty.Float64(s.MeanBacklog, 0),
+		strconv.FormatUint(s.MaxBacklog, 10),
+		pretty.Float64(s.MeanQlen, 1),
+		strconv.FormatUint(s.Drops, 10),
+		strconv.FormatUint(s.ECNMarks, 10),
+		strconv.FormatUint(s.Overlimits, 10),
+		flows,
+	}
+}
+
+// QdiscHeader contains the column headers for QdiscSummary.Row.
+var QdiscHeader = []string{"Mean Backlog (B)", "Max Backlog (B)",
+	"Mean Qlen", "Drops", "ECN Marks", "Overlimits", "Max Flows"}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/heistp/fct/netns"
+)
+
+// qdiscSample returns a sample with an HTB root qdisc, and an fq_codel qdisc
+// under it.
+func qdiscSample(t time.Time, dir string, overlimits, backlog, drops,
+	marks, flows uint64) QdiscSample {
+	return QdiscSample{t, dir, []netns.QdiscStats{
+		{Kind: "htb", Handle: "1:", Overlimits: overlimits},
+		{Kind: "fq_codel", Handle: "10:", Parent: "1:1", Backlog: backlog,
+			Qlen: backlog / 1000, Drops: drops, ECNMarks: marks,
+			Flows: flows, FlowQueues: true},
+	}}
+}
+
+func TestSummarizeQdisc(t *testing.T) {
+	t0 := time.Now()
+	samples := []QdiscSample{
+		qdiscSample(t0, BottleneckDown, 100, 0, 10, 20, 0),
+		qdiscSample(t0, BottleneckUp, 0, 9000, 0, 0, 9),
+		qdiscSample(t0.Add(time.Second), BottleneckDown, 150, 4000, 12, 25,
+			3),
+		qdiscSample(t0.Add(2*time.Second), BottleneckDown, 160, 2000, 15,
+			30, 1),
+	}
+	down := qdiscSamplesBetween(samples, BottleneckDown, t0,
+		t0.Add(2*time.Second))
+	if len(down) != 3 {
+		t.Fatalf("%d download samples, want 3", len(down))
+	}
+	s := summarizeQdisc(down)
+	want := QdiscSummary{
+		Samples:     3,
+		Kind:        "fq_codel",
+		MeanBacklog: 2000,
+		MaxBacklog:  4000,
+		MeanQlen:    2,
+		Drops:       5,
+		ECNMarks:    10,
+		Overlimits:  60,
+		MaxFlows:    3,
+		FlowQueues:  true,
+	}
+	if s != want {
+		t.Errorf("summary:\n%+v\nwant:\n%+v", s, want)
+	}
+
+	if n := len(qdiscSamplesBetween(samples, BottleneckDown, t0.Add(
+		time.Millisecond), t0.Add(time.Second))); n != 1 {
+		t.Errorf("%d samples between, want 1", n)
+	}
+}
+
+func TestSummarizeQdiscHTBOnly(t *testing.T) {
+	t0 := time.Now()
+	s := summarizeQdisc([]QdiscSample{
+		{t0, BottleneckDown, []netns.QdiscStats{
+			{Kind: "htb", Handle: "1:", Drops: 1, Overlimits: 5,
+				Backlog: 3000},
+		}},
+		{t0.Add(time.Second), BottleneckDown, []netns.QdiscStats{
+			{Kind: "htb", Handle: "1:", Drops: 4, Overlimits: 7,
+				Backlog: 1000},
+		}},
+	})
+	if s.Kind != "htb" || s.Drops != 3 || s.Overlimits != 2 ||
+		s.MaxBacklog != 3000 || s.FlowQueues {
+		t.Errorf("summary without an AQM %+v", s)
+	}
+	if r := s.Row(); r[len(r)-1] != "-" {
+		t.Errorf("max flows %v without flow queues, want -", r[len(r)-1])
+	}
+}
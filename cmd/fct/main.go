@@ -4,12 +4,16 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	ccafct "github.com/heistp/fct"
+	"github.com/heistp/fct/bitrate"
 )
 
 type Mode int
@@ -26,14 +30,103 @@ func fail(f string, a ...interface{}) {
 
 // usage emits program usage
 func usage(w io.Writer) {
-	fmt.Fprintf(w, "usage: fct client addr[:port] | server | json\n")
+	fmt.Fprint(w, `usage: fct client [-proto http|tcp] [-dir download|upload]
+           [-mix cca:weight,...] [-tos n] [-maxrate rate] [-lowat bytes]
+           [-client-cca cca] [-sndbuf bytes] [-rcvbuf bytes]
+           [-load fraction -bottleneck rate] [-loop mode] [-concurrency n]
+           [-think time] [-max-outstanding n] [-arrival model] [-len model]
+           [-cdf file] [-record file] [-replay file] [-server-log file]
+           [-stats list] addr[:port]
+       fct server [-zerocopy] [-cpu] [-flowlog file]
+       fct json
+       fct bulk server
+       fct bulk client [-n flows] [-cca cca] [-spec spec]
+           [-dir download|upload] [-t duration] [-i interval] [-tos n]
+           [-maxrate rate] addr[:port]
+       fct bulk json
+       fct probe server [-listen addr]
+       fct probe client [-i interval] [-size bytes] [-t duration] addr[:port]
+       fct probe json
+`)
 }
 
 // runClient runs the client.
-func runClient(addr string) (err error) {
+func runClient(args []string) (err error) {
 	p := ccafct.Params{}
-	p.Addr = addr
-	t := ccafct.NewTest(p)
+	fs := flag.NewFlagSet("client", flag.ExitOnError)
+	fs.StringVar(&p.Protocol, "proto", ccafct.DefaultProtocol,
+		"FCT protocol (http or tcp)")
+	fs.StringVar(&p.Direction, "dir", ccafct.DefaultDirection,
+		"flow direction (download or upload)")
+	fs.Func("mix", "weighted CCA mix, e.g. cubic:70,bbr:30",
+		func(s string) (err error) {
+			p.CCAMix, err = ccafct.ParseCCAMix(s)
+			return
+		})
+	var tos int
+	fs.IntVar(&tos, "tos", 0, "IP TOS byte for the server and client")
+	fs.Func("maxrate", "server maximum pacing rate, e.g. 10Mbps",
+		func(s string) (err error) {
+			p.ServerSockOpts.MaxPacingRate, err = bitrate.Parse(s)
+			return
+		})
+	fs.IntVar(&p.ServerSockOpts.NotSentLowat, "lowat", 0,
+		"server TCP_NOTSENT_LOWAT (0 for the system default)")
+	fs.StringVar(&p.ClientSockOpts.CCA, "client-cca", "",
+		"client CCA, if different from the server's")
+	fs.IntVar(&p.ClientSockOpts.SndBuf, "sndbuf", 0,
+		"client SO_SNDBUF (0 for the system default)")
+	fs.IntVar(&p.ClientSockOpts.RcvBuf, "rcvbuf", 0,
+		"client SO_RCVBUF (0 for the system default)")
+	fs.Float64Var(&p.Load, "load", 0,
+		"offered load as a fraction of the bottleneck rate")
+	fs.Func("bottleneck", "bottleneck rate, e.g. 50Mbps",
+		func(s string) (err error) {
+			p.Bottleneck, err = bitrate.Parse(s)
+			return
+		})
+	fs.BoolVar(&p.AllowOverload, "overload", false,
+		"allow an offered load greater than the bottleneck rate")
+	fs.StringVar(&p.Loop, "loop", ccafct.DefaultLoop,
+		"workload loop mode (open or closed)")
+	fs.IntVar(&p.Concurrency, "concurrency", ccafct.DefaultConcurrency,
+		"request slots in closed-loop mode")
+	fs.DurationVar(&p.ThinkTime, "think", 0,
+		"mean think time between flows in closed-loop mode")
+	fs.IntVar(&p.MaxOutstanding, "max-outstanding", 0,
+		"maximum outstanding flows in open-loop mode (0 for no limit)")
+	fs.StringVar(&p.Arrival.Model, "arrival", ccafct.DefaultArrivalModel,
+		"arrival model (poisson, constant, pareto-onoff or mmpp)")
+	fs.StringVar(&p.LenModel, "len", "",
+		"flow length model (lognormal, websearch, datamining or cdf)")
+	fs.StringVar(&p.LenCDFFile, "cdf", "",
+		"flow length CDF file for the cdf model")
+	fs.StringVar(&p.RecordTrace, "record", "",
+		"write the workload schedule to a CSV trace file")
+	fs.StringVar(&p.ReplayTrace, "replay", "",
+		"replay a CSV trace file of timestamp,size")
+	var serverLog string
+	fs.StringVar(&serverLog, "server-log", "",
+		"join server flow records from the server's flow log after the test")
+	set := ccafct.DefaultStatSet
+	fs.Func("stats", "comma separated statistics, e.g. "+
+		"median,p99,p99.9,mean,stddev (default "+set.String()+")",
+		func(s string) (err error) {
+			set, err = ccafct.ParseStatSet(s)
+			return
+		})
+	fs.Parse(args)
+	p.ServerSockOpts.TOS = tos
+	p.ClientSockOpts.TOS = tos
+	if fs.NArg() < 1 {
+		fail("client requires addr:port argument")
+	}
+	p.Addr = fs.Arg(0)
+
+	var t ccafct.Test
+	if t, err = ccafct.NewTest(p); err != nil {
+		return
+	}
 
 	t.Emit(os.Stdout)
 
@@ -41,19 +134,195 @@ func runClient(addr string) (err error) {
 	if data, err = t.Run(context.Background()); err != nil {
 		return
 	}
+	if serverLog != "" {
+		var sf []ccafct.ServerFlow
+		if sf, err = ccafct.ReadServerFlowsFile(serverLog); err != nil {
+			return
+		}
+		n := data.JoinServerFlows(sf)
+		fmt.Printf("Joined %d server flow records from %s\n", n, serverLog)
+	}
 	var stats ccafct.Stats
-	if stats, err = ccafct.Analyze(data); err != nil {
+	if stats, err = ccafct.Analyze(&data, set); err != nil {
 		return
 	}
 	stats.Emit(os.Stdout)
+	var timing ccafct.TimingStats
+	if timing, err = ccafct.AnalyzeTiming(&data, set); err != nil {
+		return
+	}
+	timing.Emit(os.Stdout)
+	if len(data.CCAs()) > 1 {
+		var cs ccafct.CCAStats
+		if cs, err = ccafct.AnalyzeCCA(&data, set); err != nil {
+			return
+		}
+		cs.Emit(os.Stdout)
+	}
+	ccafct.AnalyzeTransport(&data).Emit(os.Stdout)
+	if data.Dropped > 0 {
+		fmt.Printf("Dropped: %d\n", data.Dropped)
+	}
 
 	return
 }
 
-// runServer runs the server.
-func runServer() error {
+// runServer runs the server until interrupted.
+func runServer(args []string) (err error) {
 	s := new(ccafct.Server)
-	return s.Run()
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	fs.BoolVar(&s.ZeroCopy, "zerocopy", false,
+		"send raw protocol payloads with sendfile from a memory-backed file")
+	fs.BoolVar(&s.FlowCPU, "cpu", false,
+		"record each flow's CPU time, using a thread per active flow")
+	var flowLog string
+	fs.StringVar(&flowLog, "flowlog", "",
+		"append a record of each flow to a file, as NDJSON")
+	fs.Parse(args)
+
+	if flowLog != "" {
+		var f *os.File
+		if f, err = os.OpenFile(flowLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+			0644); err != nil {
+			return
+		}
+		defer f.Close()
+		s.FlowLog = f
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+	err = s.Run(ctx)
+	return
+}
+
+// runBulk runs the bulk server, client or JSON mode. Until interrupted, the
+// server runs, or the client runs and writes samples to stdout as NDJSON.
+func runBulk(args []string) (err error) {
+	if len(args) < 1 {
+		fail("bulk requires server, client or json argument")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+
+	var b ccafct.Bulk
+	switch args[0] {
+	case "server":
+		s := new(ccafct.BulkServer)
+		fs := flag.NewFlagSet("bulk server", flag.ExitOnError)
+		fs.StringVar(&s.ListenAddr, "listen", ccafct.DefaultBulkListenAddr,
+			"listen address")
+		fs.Parse(args[1:])
+		err = s.Run(ctx)
+		return
+	case "client":
+		fs := flag.NewFlagSet("bulk client", flag.ExitOnError)
+		fs.IntVar(&b.Flows, "n", ccafct.DefaultBulkFlows, "number of flows")
+		fs.StringVar(&b.CCA, "cca", ccafct.DefaultCCA, "CCA")
+		fs.Func("spec", "flow spec instead of -n and -cca, "+
+			"e.g. cubic+4xbbr:every=2s", func(s string) (err error) {
+			b.Spec, err = ccafct.ParseBulkSpec(s)
+			return
+		})
+		fs.StringVar(&b.Direction, "dir", ccafct.DefaultDirection,
+			"flow direction (download or upload)")
+		fs.DurationVar(&b.Duration, "t", 0,
+			"duration (0 to run until interrupted)")
+		fs.DurationVar(&b.Interval, "i", ccafct.DefaultBulkInterval,
+			"sample interval")
+		var tos int
+		fs.IntVar(&tos, "tos", 0, "IP TOS byte for the server and client")
+		fs.Func("maxrate", "server maximum pacing rate, e.g. 10Mbps",
+			func(s string) (err error) {
+				b.ServerSockOpts.MaxPacingRate, err = bitrate.Parse(s)
+				return
+			})
+		fs.Parse(args[1:])
+		b.ServerSockOpts.TOS = tos
+		b.ClientSockOpts.TOS = tos
+		if fs.NArg() < 1 {
+			fail("bulk client requires addr:port argument")
+		}
+		b.Addr = fs.Arg(0)
+	case "json":
+		if err = json.NewDecoder(bufio.NewReader(os.Stdin)).Decode(
+			&b); err != nil {
+			return
+		}
+	default:
+		fail("unknown bulk command '%s'", args[0])
+	}
+
+	bw := bufio.NewWriter(os.Stdout)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+	err = b.Run(ctx, func(s ccafct.BulkSample) {
+		if e := enc.Encode(&s); e != nil {
+			log.Printf("unable to write sample: '%s'", e)
+		}
+		bw.Flush()
+	})
+	return
+}
+
+// runProbe runs the probe server, client or JSON mode. The server runs until
+// interrupted. The client sends probes until interrupted or its duration
+// elapses, then writes the samples to stdout as NDJSON.
+func runProbe(args []string) (err error) {
+	if len(args) < 1 {
+		fail("probe requires server, client or json argument")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+
+	var p ccafct.Probe
+	switch args[0] {
+	case "server":
+		s := new(ccafct.ProbeServer)
+		fs := flag.NewFlagSet("probe server", flag.ExitOnError)
+		fs.StringVar(&s.ListenAddr, "listen", ccafct.DefaultProbeListenAddr,
+			"listen address")
+		fs.Parse(args[1:])
+		err = s.Run(ctx)
+		return
+	case "client":
+		fs := flag.NewFlagSet("probe client", flag.ExitOnError)
+		fs.DurationVar(&p.Interval, "i", ccafct.DefaultProbeInterval,
+			"probe interval")
+		fs.IntVar(&p.Size, "size", ccafct.DefaultProbeSize,
+			"probe size, in bytes of UDP payload")
+		fs.DurationVar(&p.Duration, "t", 0,
+			"duration (0 to run until interrupted)")
+		fs.Parse(args[1:])
+		if fs.NArg() < 1 {
+			fail("probe client requires addr:port argument")
+		}
+		p.Addr = fs.Arg(0)
+	case "json":
+		if err = json.NewDecoder(bufio.NewReader(os.Stdin)).Decode(
+			&p); err != nil {
+			return
+		}
+	default:
+		fail("unknown probe command '%s'", args[0])
+	}
+
+	var samples []ccafct.ProbeSample
+	if samples, err = p.Run(ctx); err != nil {
+		return
+	}
+	bw := bufio.NewWriter(os.Stdout)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+	for _, s := range samples {
+		if err = enc.Encode(&s); err != nil {
+			return
+		}
+	}
+	return
 }
 
 // runJSON runs JSON mode.
@@ -97,19 +366,20 @@ func main() {
 
 	switch cmd {
 	case "client":
-		if len(os.Args) < 3 {
-			fail("client requires addr:port argument")
-		}
-		err = runClient(os.Args[2])
+		err = runClient(os.Args[2:])
 	case "server":
-		err = runServer()
+		err = runServer(os.Args[2:])
 	case "json":
 		err = runJSON()
+	case "bulk":
+		err = runBulk(os.Args[2:])
+	case "probe":
+		err = runProbe(os.Args[2:])
 	default:
 		fail("unknown command '%s'", cmd)
 	}
 
 	if err != nil {
-		fail(err.Error())
+		fail("%s", err)
 	}
 }
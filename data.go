@@ -1,9 +1,11 @@
 package ccafct
 
 import (
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/heistp/fct/bitrate"
 	"github.com/heistp/fct/unit"
 )
 
@@ -26,6 +28,42 @@ type Flow struct {
 
 	// Length is the flow length.
 	Length unit.Bytes
+
+	// CCA is the flow's congestion control algorithm.
+	CCA string `json:",omitempty"`
+
+	// Upload is true if the flow was sent from the client to the server. For
+	// upload flows, End and FirstByte are when the server's acknowledgement
+	// was received, after it received the whole flow.
+	Upload bool `json:",omitempty"`
+
+	// DNSDone is when the DNS lookup completed, or zero if there was none.
+	DNSDone time.Time
+
+	// ConnectStart is when the TCP connection was started.
+	ConnectStart time.Time
+
+	// Connected is when the TCP handshake completed.
+	Connected time.Time
+
+	// WroteRequest is when the request was written.
+	WroteRequest time.Time
+
+	// FirstByte is when the first response byte was received.
+	FirstByte time.Time
+
+	// ID is the flow ID sent to the server, which identifies the flow in the
+	// server's records.
+	ID uint64
+
+	// Server is the server's record of the flow, or nil if the server didn't
+	// return it. It may be joined from the server's flow log with
+	// JoinServerFlows.
+	Server *ServerFlow `json:",omitempty"`
+
+	// ClientTCPInfo is the client's TCP_INFO for upload flows, taken after
+	// the server acknowledged the whole flow, or nil if it was unavailable.
+	ClientTCPInfo *TCPInfo `json:",omitempty"`
 }
 
 // Duration returns the flow duration.
@@ -33,6 +71,41 @@ func (f Flow) Duration() time.Duration {
 	return f.End.Sub(f.Start)
 }
 
+// SenderTCPInfo returns the TCP_INFO from the flow's sender, which is the
+// client for uploads and the server for downloads, or nil if it's unavailable.
+func (f Flow) SenderTCPInfo() *TCPInfo {
+	if f.Upload {
+		return f.ClientTCPInfo
+	}
+	if f.Server == nil {
+		return nil
+	}
+	return f.Server.TCPInfo
+}
+
+// Timed returns true if the flow's timing breakdown was recorded.
+func (f Flow) Timed() bool {
+	return !f.ConnectStart.IsZero() && !f.Connected.IsZero() &&
+		!f.FirstByte.IsZero()
+}
+
+// Handshake returns the time from the start of the TCP connection until the
+// handshake completed.
+func (f Flow) Handshake() time.Duration {
+	return f.Connected.Sub(f.ConnectStart)
+}
+
+// TTFB returns the time from the completion of the TCP handshake until the
+// first response byte was received.
+func (f Flow) TTFB() time.Duration {
+	return f.FirstByte.Sub(f.Connected)
+}
+
+// Transfer returns the time from the first to the last response byte.
+func (f Flow) Transfer() time.Duration {
+	return f.End.Sub(f.FirstByte)
+}
+
 // Data contains data gathered during a test.
 type Data struct {
 	// Flow contains the flow data.
@@ -44,6 +117,10 @@ type Data struct {
 	// End is the test end time.
 	End time.Time
 
+	// Dropped is the number of flows that were not started because the
+	// maximum number of outstanding flows was reached.
+	Dropped int
+
 	sync.Mutex
 }
 
@@ -52,6 +129,7 @@ func newData() Data {
 		make([]Flow, 0, flowInitCap),
 		time.Time{},
 		time.Time{},
+		0,
 		sync.Mutex{},
 	}
 }
@@ -71,3 +149,76 @@ func (d *Data) FlowDurations() (durs []time.Duration) {
 	}
 	return
 }
+
+// CCAs returns the distinct flow CCAs, sorted by name.
+func (d *Data) CCAs() (ccas []string) {
+	seen := make(map[string]bool)
+	for _, f := range d.Flow {
+		if !seen[f.CCA] {
+			seen[f.CCA] = true
+			ccas = append(ccas, f.CCA)
+		}
+	}
+	sort.Strings(ccas)
+	return
+}
+
+// CCADurations returns a slice of flow durations for the given CCA.
+func (d *Data) CCADurations(cca string) (durs []time.Duration) {
+	for _, f := range d.Flow {
+		if f.CCA == cca {
+			durs = append(durs, f.Duration())
+		}
+	}
+	return
+}
+
+// Goodput returns the rate at which flow payloads were transferred, from the
+// test start to end.
+func (d *Data) Goodput() bitrate.Bitrate {
+	dur := d.End.Sub(d.Start)
+	if dur <= 0 {
+		return 0
+	}
+	var total unit.Bytes
+	for _, f := range d.Flow {
+		total += f.Length
+	}
+	return bitrate.Bitrate(float64(total) * 8 / dur.Seconds())
+}
+
+// TimedDurations returns a slice of durations given by dur, for all flows with
+// a recorded timing breakdown.
+func (d *Data) TimedDurations(dur func(Flow) time.Duration) (
+	durs []time.Duration) {
+	durs = make([]time.Duration, 0, len(d.Flow))
+	for _, f := range d.Flow {
+		if f.Timed() {
+			durs = append(durs, dur(f))
+		}
+	}
+	return
+}
+
+// JoinServerFlows sets the Server record for flows without one from the
+// server's flow records, matching them by flow ID, and returns the number of
+// flows joined. Records with an ID of zero are ignored.
+func (d *Data) JoinServerFlows(sf []ServerFlow) (n int) {
+	m := make(map[uint64]*ServerFlow, len(sf))
+	for i := range sf {
+		if sf[i].ID != 0 {
+			m[sf[i].ID] = &sf[i]
+		}
+	}
+	for i := range d.Flow {
+		f := &d.Flow[i]
+		if f.Server != nil {
+			continue
+		}
+		if s, ok := m[f.ID]; ok {
+			f.Server = s
+			n++
+		}
+	}
+	return
+}
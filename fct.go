@@ -3,11 +3,63 @@ package ccafct
 // defaultPort is the default server listen port.
 var DefaultPort = 8188
 
+// DefaultRawPort is the default server listen port for the raw protocol.
+var DefaultRawPort = 8189
+
+// DefaultBulkPort is the default listen port for the bulk server.
+var DefaultBulkPort = 8190
+
+// DefaultProbePort is the default UDP listen port for the probe server.
+var DefaultProbePort = 8191
+
+// FCT protocols.
+const (
+	// ProtocolHTTP runs each flow as an HTTP GET request.
+	ProtocolHTTP = "http"
+
+	// ProtocolTCP runs each flow using the raw binary protocol over a new TCP
+	// connection, avoiding the overhead of net/http.
+	ProtocolTCP = "tcp"
+)
+
+// Flow directions.
+const (
+	// Download flows are sent from the server to the client.
+	Download = "download"
+
+	// Upload flows are sent from the client to the server, which
+	// acknowledges them once they're received.
+	Upload = "upload"
+)
+
 // FlowLengthHeader is the HTTP header for the flow length.
 var FlowLengthHeader = "FCT-Flow-Length"
 
 // CCAHeader is the HTTP header for the CC algo.
 var CCAHeader = "FCT-CCA"
 
+// FlowIDHeader is the HTTP header for the flow ID.
+var FlowIDHeader = "FCT-Flow-ID"
+
+// ServerFlowHeader is the HTTP trailer containing the server's ServerFlow
+// record, in JSON.
+var ServerFlowHeader = "FCT-Server-Flow"
+
+// TOSHeader is the HTTP header for the server's IP TOS byte.
+var TOSHeader = "FCT-TOS"
+
+// MaxPacingRateHeader is the HTTP header for the server's maximum pacing rate,
+// in bits per second.
+var MaxPacingRateHeader = "FCT-Max-Pacing-Rate"
+
+// SndBufHeader is the HTTP header for the server's send buffer size.
+var SndBufHeader = "FCT-SndBuf"
+
+// RcvBufHeader is the HTTP header for the server's receive buffer size.
+var RcvBufHeader = "FCT-RcvBuf"
+
+// NotSentLowatHeader is the HTTP header for the server's TCP_NOTSENT_LOWAT.
+var NotSentLowatHeader = "FCT-NotSent-Lowat"
+
 // FCTPath is the URL path of the FCT test handler.
 var FCTPath = "/fct"
@@ -0,0 +1,238 @@
+package ccafct
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/heistp/fct/unit"
+	"golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Flow length models.
+const (
+	// LenLogNormal is a lognormal distribution, set from Params.LenP5 and
+	// Params.LenP95.
+	LenLogNormal = "lognormal"
+
+	// LenWebSearch is the empirical web search workload from the DCTCP paper
+	// (Alizadeh et al., SIGCOMM 2010).
+	LenWebSearch = "websearch"
+
+	// LenDataMining is the empirical data mining workload from the VL2 paper
+	// (Greenberg et al., SIGCOMM 2009).
+	LenDataMining = "datamining"
+
+	// LenCDF is an empirical distribution read from Params.LenCDFFile.
+	LenCDF = "cdf"
+)
+
+var DefaultLenModel = LenLogNormal
+
+// cdfMSS is the segment size used to convert the built-in CDFs, which are
+// given in packets as in the pFabric simulations, to bytes.
+const cdfMSS = 1460
+
+// CDFPoint is one point in an empirical cumulative distribution function.
+type CDFPoint struct {
+	// Size is the flow size.
+	Size unit.Bytes
+
+	// P is the cumulative probability that a flow is no larger than Size.
+	P float64
+}
+
+// CDF is an empirical cumulative distribution function of flow sizes, with
+// linear interpolation between points.
+type CDF []CDFPoint
+
+// packetCDF returns a CDF from points given in packets.
+func packetCDF(pts [][2]float64) (c CDF) {
+	c = make(CDF, len(pts))
+	for i, p := range pts {
+		c[i] = CDFPoint{unit.Bytes(p[0] * cdfMSS), p[1]}
+	}
+	return
+}
+
+// WebSearchCDF is the LenWebSearch CDF.
+var WebSearchCDF = packetCDF([][2]float64{
+	{6, 0}, {6, 0.15}, {13, 0.2}, {19, 0.3}, {33, 0.4}, {53, 0.53},
+	{133, 0.6}, {667, 0.7}, {1333, 0.8}, {3333, 0.9}, {6667, 0.97},
+	{20000, 1},
+})
+
+// DataMiningCDF is the LenDataMining CDF.
+var DataMiningCDF = packetCDF([][2]float64{
+	{1, 0}, {1, 0.5}, {2, 0.6}, {3, 0.7}, {7, 0.8}, {267, 0.9},
+	{2107, 0.95}, {66667, 0.99}, {666667, 1},
+})
+
+// Validate returns an error if the CDF is empty, has a point out of range, is
+// not monotonic, or does not end with a probability of 1.
+func (c CDF) Validate() error {
+	if len(c) == 0 {
+		return fmt.Errorf("CDF is empty")
+	}
+	for i, p := range c {
+		if p.Size < 0 || math.IsNaN(p.P) || p.P < 0 || p.P > 1 {
+			return fmt.Errorf("CDF point %d out of range: %d %f", i, p.Size,
+				p.P)
+		}
+		if i > 0 && (p.Size < c[i-1].Size || p.P < c[i-1].P) {
+			return fmt.Errorf("CDF not monotonic at point %d", i)
+		}
+	}
+	if math.Abs(c[len(c)-1].P-1) > 1e-6 {
+		return fmt.Errorf("CDF must end with probability 1, not %f",
+			c[len(c)-1].P)
+	}
+	return nil
+}
+
+// Mean returns the mean flow size.
+func (c CDF) Mean() (mean float64) {
+	mean = float64(c[0].Size) * c[0].P
+	for i := 1; i < len(c); i++ {
+		mean += (c[i].P - c[i-1].P) * float64(c[i].Size+c[i-1].Size) / 2
+	}
+	return
+}
+
+// Quantile returns the flow size at cumulative probability p.
+func (c CDF) Quantile(p float64) float64 {
+	i := sort.Search(len(c), func(i int) bool {
+		return c[i].P >= p
+	})
+	switch {
+	case i == 0:
+		return float64(c[0].Size)
+	case i == len(c):
+		return float64(c[len(c)-1].Size)
+	}
+	a, b := c[i-1], c[i]
+	if b.P == a.P {
+		return float64(b.Size)
+	}
+	return float64(a.Size) + (p-a.P)/(b.P-a.P)*float64(b.Size-a.Size)
+}
+
+// ReadCDF reads a CDF with one point per line, consisting of a size in bytes
+// and a cumulative probability, separated by whitespace or a comma.
+// Probabilities may be given as fractions, or as percentages if the last one
+// is 100. Blank lines and lines starting with '#' are ignored.
+func ReadCDF(r io.Reader) (c CDF, err error) {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		f := strings.FieldsFunc(l, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(f) < 2 {
+			err = fmt.Errorf("CDF line %d: expected size and probability", n)
+			return
+		}
+		var size, p float64
+		var serr, perr error
+		size, serr = strconv.ParseFloat(f[0], 64)
+		p, perr = strconv.ParseFloat(f[1], 64)
+		if serr != nil || perr != nil || math.IsNaN(size) ||
+			math.IsInf(size, 0) || math.IsNaN(p) || math.IsInf(p, 0) {
+			err = fmt.Errorf("CDF line %d: invalid point: '%s'", n, l)
+			return
+		}
+		c = append(c, CDFPoint{unit.Bytes(size), p})
+	}
+	if err = s.Err(); err != nil {
+		return
+	}
+	if len(c) > 0 && c[len(c)-1].P == 100 {
+		for i := range c {
+			c[i].P /= 100
+		}
+	}
+	err = c.Validate()
+	return
+}
+
+// ReadCDFFile reads a CDF from the named file.
+func ReadCDFFile(name string) (c CDF, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+	if c, err = ReadCDF(f); err != nil {
+		err = fmt.Errorf("%s: %s", name, err)
+	}
+	return
+}
+
+// LenModelCDF returns the CDF for an empirical flow length model, or nil for
+// the lognormal model. For the cdf model, the CDF is read from file, which
+// must be empty for the other models.
+func LenModelCDF(model, file string) (c CDF, err error) {
+	if file != "" && model != LenCDF {
+		err = fmt.Errorf("a CDF file was given for the %s flow length "+
+			"model (set the model to %s to use it)", model, LenCDF)
+		return
+	}
+	switch model {
+	case LenLogNormal:
+	case LenWebSearch:
+		c = WebSearchCDF
+	case LenDataMining:
+		c = DataMiningCDF
+	case LenCDF:
+		if file == "" {
+			err = fmt.Errorf("the %s flow length model requires a CDF file",
+				LenCDF)
+			return
+		}
+		c, err = ReadCDFFile(file)
+	default:
+		err = fmt.Errorf("unknown flow length model: '%s'", model)
+	}
+	return
+}
+
+// FlowSizeDistribution is a distribution of flow sizes.
+type FlowSizeDistribution interface {
+	// Rand returns a random flow size.
+	Rand() unit.Bytes
+
+	// Mean returns the mean flow size.
+	Mean() float64
+
+	// Quantile returns the flow size at cumulative probability p.
+	Quantile(p float64) float64
+}
+
+// logNormalSize is a lognormal FlowSizeDistribution.
+type logNormalSize struct {
+	distuv.LogNormal
+}
+
+func (l logNormalSize) Rand() unit.Bytes {
+	return unit.Bytes(l.LogNormal.Rand())
+}
+
+// empiricalSize is an empirical FlowSizeDistribution, sampled by inverse
+// transform.
+type empiricalSize struct {
+	CDF
+	rnd *rand.Rand
+}
+
+func (e empiricalSize) Rand() unit.Bytes {
+	return unit.Bytes(e.CDF.Quantile(e.rnd.Float64()))
+}
@@ -0,0 +1,105 @@
+package ccafct
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/rand"
+)
+
+func TestReadCDFPercentages(t *testing.T) {
+	want := CDF{{100, 0}, {100, 0.5}, {1000, 1}}
+	for _, in := range []string{
+		"# size p\n100 0\n100 0.5\n\n1000,1\n",
+		"100\t0\n100\t50\n1000\t100\n",
+	} {
+		c, err := ReadCDF(strings.NewReader(in))
+		if err != nil {
+			t.Errorf("ReadCDF(%q): %s", in, err)
+		} else if !reflect.DeepEqual(c, want) {
+			t.Errorf("ReadCDF(%q) = %v, want %v", in, c, want)
+		}
+	}
+}
+
+func TestReadCDFRejects(t *testing.T) {
+	for _, in := range []string{
+		"# nothing\n",
+		"100\n",
+		"100 0.5\nx 1\n",
+		"1000 0.5\n100 1\n",
+		"100 0.6\n1000 0.5\n2000 1\n",
+		"100 0.5\n1000 0.9\n",
+		"100 0.5\n1000 2\n",
+		"1000 NaN\n",
+		"100 0.5\n1000 NaN\n",
+		"NaN 1\n",
+		"100 0.5\nInf 1\n",
+		"100 -Inf\n1000 1\n",
+	} {
+		if c, err := ReadCDF(strings.NewReader(in)); err == nil {
+			t.Errorf("ReadCDF(%q) = %v, want error", in, c)
+		}
+	}
+}
+
+func TestCDFValidateNaN(t *testing.T) {
+	if err := (CDF{{1000, math.NaN()}}).Validate(); err == nil {
+		t.Error("Validate accepted a NaN probability")
+	}
+}
+
+func TestCDFMeanQuantile(t *testing.T) {
+	c := CDF{{0, 0}, {100, 0.5}, {1000, 1}}
+	if m := c.Mean(); m != 300 {
+		t.Errorf("Mean() = %f, want 300", m)
+	}
+	for _, q := range []struct {
+		p    float64
+		size float64
+	}{
+		{0, 0}, {0.25, 50}, {0.5, 100}, {0.75, 550}, {1, 1000},
+	} {
+		if s := c.Quantile(q.p); s != q.size {
+			t.Errorf("Quantile(%g) = %f, want %f", q.p, s, q.size)
+		}
+	}
+}
+
+func TestEmpiricalSizeMean(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		cdf  CDF
+	}{
+		{LenWebSearch, WebSearchCDF},
+		{LenDataMining, DataMiningCDF},
+	} {
+		e := empiricalSize{c.cdf, rand.New(rand.NewSource(1))}
+		const n = 1000000
+		var total float64
+		for i := 0; i < n; i++ {
+			total += float64(e.Rand())
+		}
+		if m, want := total/n, c.cdf.Mean(); math.Abs(m-want)/want > 0.05 {
+			t.Errorf("%s: sampled mean %.0f, want %.0f", c.name, m, want)
+		}
+	}
+}
+
+func TestLenModelCDF(t *testing.T) {
+	if _, err := LenModelCDF(LenWebSearch, "sizes.txt"); err == nil {
+		t.Error("LenModelCDF accepted a CDF file for the websearch model")
+	}
+	if _, err := LenModelCDF(LenCDF, ""); err == nil {
+		t.Error("LenModelCDF accepted the cdf model without a file")
+	}
+	tst, err := NewTest(Params{LenModel: LenDataMining})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := int(DataMiningCDF.Mean()); tst.MeanFlowLen != m {
+		t.Errorf("mean flow length %d, want %d", tst.MeanFlowLen, m)
+	}
+}
@@ -3,6 +3,7 @@ module github.com/heistp/fct
 go 1.16
 
 require (
+	golang.org/x/exp v0.0.0-20190125153040-c74c464bbbf2
 	golang.org/x/sys v0.0.0-20210303074136-134d130e1a04
 	gonum.org/v1/gonum v0.8.2
 )
@@ -0,0 +1,24 @@
+package metric
+
+import (
+	"fmt"
+
+	"github.com/heistp/fct/bitrate"
+	"github.com/heistp/fct/harm"
+)
+
+type Throughput struct {
+	bitrate.Bitrate
+	Harm harm.Harm
+}
+
+func (t *Throughput) SetHarm(solo Throughput) {
+	t.Harm = harm.MoreIsBetter(float64(solo.Bitrate), float64(t.Bitrate))
+}
+
+func (t Throughput) String() string {
+	if t.Harm.Zero() {
+		return t.Bitrate.String()
+	}
+	return fmt.Sprintf("%s (%s)", t.Bitrate, t.Harm)
+}
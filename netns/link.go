@@ -0,0 +1,67 @@
+package netns
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// LinkStats contains the counters for a network device, as reported by
+// ip -s link.
+type LinkStats struct {
+	// RxBytes is the number of bytes received.
+	RxBytes uint64
+
+	// RxPackets is the number of packets received.
+	RxPackets uint64
+
+	// TxBytes is the number of bytes sent.
+	TxBytes uint64
+
+	// TxPackets is the number of packets sent.
+	TxPackets uint64
+
+	// TxDropped is the number of packets dropped on transmit.
+	TxDropped uint64
+}
+
+// ipLink is one device in the JSON output of ip -s -j link.
+type ipLink struct {
+	Stats64 struct {
+		Rx struct {
+			Bytes   uint64
+			Packets uint64
+		}
+		Tx struct {
+			Bytes   uint64
+			Packets uint64
+			Dropped uint64
+		}
+	}
+}
+
+// ParseLinkStats parses the JSON output of ip -s -j link for one device.
+func ParseLinkStats(b []byte) (stats LinkStats, err error) {
+	var l []ipLink
+	if err = json.Unmarshal(b, &l); err != nil {
+		err = fmt.Errorf("unable to parse link stats: %s", err)
+		return
+	}
+	if len(l) != 1 {
+		err = fmt.Errorf("expected stats for one link, got %d", len(l))
+		return
+	}
+	s := l[0].Stats64
+	stats = LinkStats{s.Rx.Bytes, s.Rx.Packets, s.Tx.Bytes, s.Tx.Packets,
+		s.Tx.Dropped}
+	return
+}
+
+// LinkStats returns the counters for a device in a namespace.
+func (r *Rig) LinkStats(name, dev string) (stats LinkStats, err error) {
+	var b []byte
+	if b, err = nsOutput(name, "ip", "-s", "-j", "link", "show", "dev",
+		dev); err != nil {
+		return
+	}
+	return ParseLinkStats(b)
+}
@@ -0,0 +1,27 @@
+package netns
+
+import "testing"
+
+func TestParseLinkStats(t *testing.T) {
+	s, err := ParseLinkStats([]byte(`[{"ifindex":2,"ifname":"veth0",
+"stats64":{"rx":{"bytes":1000,"packets":10,"errors":0,"dropped":1},
+"tx":{"bytes":5000,"packets":50,"errors":0,"dropped":3}}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (LinkStats{1000, 10, 5000, 50, 3}); s != want {
+		t.Errorf("parsed %+v, want %+v", s, want)
+	}
+}
+
+func TestParseLinkStatsRejects(t *testing.T) {
+	for _, b := range []string{
+		`Device "veth9" does not exist.`,
+		`[]`,
+		`[{"ifname":"veth0"},{"ifname":"veth1"}]`,
+	} {
+		if s, err := ParseLinkStats([]byte(b)); err == nil {
+			t.Errorf("ParseLinkStats(%q) = %+v, want error", b, s)
+		}
+	}
+}
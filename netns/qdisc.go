@@ -0,0 +1,153 @@
+package netns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// QdiscStats contains the statistics for one qdisc, as reported by tc -s.
+type QdiscStats struct {
+	// Kind is the qdisc kind, e.g. htb or fq_codel.
+	Kind string
+
+	// Handle is the qdisc's handle, e.g. 1:.
+	Handle string
+
+	// Parent is the qdisc's parent, e.g. 1:1, or empty for the root qdisc.
+	Parent string
+
+	// Bytes is the number of bytes sent.
+	Bytes uint64
+
+	// Packets is the number of packets sent.
+	Packets uint64
+
+	// Drops is the number of packets dropped.
+	Drops uint64
+
+	// Overlimits is the number of times the qdisc was over its limit, which
+	// for a shaper like HTB means a packet had to wait for tokens.
+	Overlimits uint64
+
+	// Requeues is the number of packets requeued.
+	Requeues uint64
+
+	// Backlog is the number of bytes queued.
+	Backlog uint64
+
+	// Qlen is the number of packets queued.
+	Qlen uint64
+
+	// ECNMarks is the number of packets ECN marked, for qdiscs that report
+	// it.
+	ECNMarks uint64
+
+	// Flows is the number of active flow queues, for flow queueing qdiscs
+	// like fq_codel and cake.
+	Flows uint64
+
+	// FlowQueues is true if the qdisc reports Flows.
+	FlowQueues bool
+}
+
+// tcQdisc is one qdisc in the JSON output of tc -s -j qdisc. Fields that only
+// some qdiscs report are pointers, so their absence can be detected.
+type tcQdisc struct {
+	Kind        string
+	Handle      string
+	Parent      string
+	Bytes       uint64
+	Packets     uint64
+	Drops       uint64
+	Overlimits  uint64
+	Requeues    uint64
+	Backlog     uint64
+	Qlen        uint64
+	ECNMark     *uint64 `json:"ecn_mark"`
+	Marked      *uint64 `json:"marked"`
+	NewFlowsLen *uint64 `json:"new_flows_len"`
+	OldFlowsLen *uint64 `json:"old_flows_len"`
+	Tins        []struct {
+		ECNMark     uint64 `json:"ecn_mark"`
+		SparseFlows uint64 `json:"sparse_flows"`
+		BulkFlows   uint64 `json:"bulk_flows"`
+	} `json:"tins"`
+}
+
+// stats returns the QdiscStats for the tc output.
+func (q tcQdisc) stats() (s QdiscStats) {
+	s = QdiscStats{
+		Kind:       q.Kind,
+		Handle:     q.Handle,
+		Parent:     q.Parent,
+		Bytes:      q.Bytes,
+		Packets:    q.Packets,
+		Drops:      q.Drops,
+		Overlimits: q.Overlimits,
+		Requeues:   q.Requeues,
+		Backlog:    q.Backlog,
+		Qlen:       q.Qlen,
+	}
+	switch {
+	case q.ECNMark != nil:
+		s.ECNMarks = *q.ECNMark
+	case q.Marked != nil:
+		s.ECNMarks = *q.Marked
+	}
+	if q.NewFlowsLen != nil || q.OldFlowsLen != nil {
+		s.FlowQueues = true
+		if q.NewFlowsLen != nil {
+			s.Flows += *q.NewFlowsLen
+		}
+		if q.OldFlowsLen != nil {
+			s.Flows += *q.OldFlowsLen
+		}
+	}
+	for _, t := range q.Tins {
+		s.ECNMarks += t.ECNMark
+		s.Flows += t.SparseFlows + t.BulkFlows
+		s.FlowQueues = true
+	}
+	return
+}
+
+// ParseQdiscStats parses the JSON output of tc -s -j qdisc.
+func ParseQdiscStats(b []byte) (stats []QdiscStats, err error) {
+	var q []tcQdisc
+	if err = json.Unmarshal(b, &q); err != nil {
+		err = fmt.Errorf("unable to parse tc qdisc stats: %s", err)
+		return
+	}
+	for _, v := range q {
+		stats = append(stats, v.stats())
+	}
+	return
+}
+
+// QdiscStats returns the stats for the qdiscs on a device in a namespace.
+func (r *Rig) QdiscStats(name, dev string) (stats []QdiscStats, err error) {
+	var b []byte
+	if b, err = nsOutput(name, "tc", "-s", "-j", "qdisc", "show", "dev",
+		dev); err != nil {
+		return
+	}
+	return ParseQdiscStats(b)
+}
+
+// nsOutput runs a command in a namespace and returns its stdout. The command
+// isn't traced, as it's meant for polling stats.
+func nsOutput(name string, arg ...string) (out []byte, err error) {
+	c := exec.Command("ip", append([]string{"netns", "exec", name},
+		arg...)...)
+	if out, err = c.Output(); err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			err = fmt.Errorf("'%s' failed: %s (%s)", c, err,
+				strings.TrimSpace(string(ee.Stderr)))
+		}
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package netns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQdiscStats(t *testing.T) {
+	stats, err := ParseQdiscStats([]byte(`[
+{"kind":"htb","handle":"1:","root":true,"bytes":1000,"packets":10,
+ "drops":0,"overlimits":42,"requeues":0,"backlog":0,"qlen":0},
+{"kind":"fq_codel","handle":"10:","parent":"1:1","bytes":900,"packets":9,
+ "drops":2,"overlimits":0,"requeues":1,"backlog":3000,"qlen":2,
+ "ecn_mark":5,"new_flows_len":1,"old_flows_len":2},
+{"kind":"cake","handle":"20:","parent":"1:1","backlog":1500,"qlen":1,
+ "tins":[{"ecn_mark":3,"sparse_flows":1,"bulk_flows":1},
+  {"ecn_mark":4,"sparse_flows":0,"bulk_flows":2}]},
+{"kind":"pie","handle":"30:","parent":"1:1","marked":6}
+]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []QdiscStats{
+		{Kind: "htb", Handle: "1:", Bytes: 1000, Packets: 10, Overlimits: 42},
+		{Kind: "fq_codel", Handle: "10:", Parent: "1:1", Bytes: 900,
+			Packets: 9, Drops: 2, Requeues: 1, Backlog: 3000, Qlen: 2,
+			ECNMarks: 5, Flows: 3, FlowQueues: true},
+		{Kind: "cake", Handle: "20:", Parent: "1:1", Backlog: 1500, Qlen: 1,
+			ECNMarks: 7, Flows: 4, FlowQueues: true},
+		{Kind: "pie", Handle: "30:", Parent: "1:1", ECNMarks: 6},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("parsed:\n%+v\nwant:\n%+v", stats, want)
+	}
+}
+
+func TestParseQdiscStatsInvalid(t *testing.T) {
+	if _, err := ParseQdiscStats([]byte("Error: no such device")); err == nil {
+		t.Error("ParseQdiscStats accepted non-JSON output")
+	}
+}
@@ -0,0 +1,253 @@
+package ccafct
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The latency probe is a UDP echo. Each probe packet contains a uint64 big
+// endian sequence number, and a uint64 big endian send time in nanoseconds
+// since the prober started, padded with zeros to the probe size. The server
+// echoes each packet back unchanged.
+
+// MinProbeSize is the minimum probe packet size, which is the length of the
+// probe packet header.
+const MinProbeSize = 16
+
+// DefaultProbeListenAddr is the default listen address for the probe server.
+var DefaultProbeListenAddr = fmt.Sprintf(":%d", DefaultProbePort)
+
+// DefaultProbeInterval is the default interval between probe packets.
+var DefaultProbeInterval = 10 * time.Millisecond
+
+// DefaultProbeSize is the default probe packet size, in bytes of UDP payload.
+var DefaultProbeSize = 64
+
+// DefaultProbeTimeout is how long the prober waits for outstanding replies
+// after it stops sending.
+var DefaultProbeTimeout = 1 * time.Second
+
+// ProbeSample is the result for one probe packet.
+type ProbeSample struct {
+	// Seq is the packet's sequence number, from zero.
+	Seq uint64
+
+	// Time is when the packet was sent.
+	Time time.Time
+
+	// RTT is the round-trip time, or zero if no reply was received.
+	RTT time.Duration `json:",omitempty"`
+}
+
+// Lost returns true if no reply was received for the packet.
+func (s ProbeSample) Lost() bool {
+	return s.RTT == 0
+}
+
+// ReadProbeSamples reads ProbeSamples in NDJSON form.
+func ReadProbeSamples(r io.Reader) (samples []ProbeSample, err error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var s ProbeSample
+		if err = dec.Decode(&s); err == io.EOF {
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+		samples = append(samples, s)
+	}
+}
+
+// ProbeSamplesBetween returns the samples for packets sent after start, and
+// no later than end.
+func ProbeSamplesBetween(samples []ProbeSample, start, end time.Time) (
+	between []ProbeSample) {
+	for _, s := range samples {
+		if s.Time.After(start) && !s.Time.After(end) {
+			between = append(between, s)
+		}
+	}
+	return
+}
+
+// ProbeServer is the UDP echo server for the latency probe.
+type ProbeServer struct {
+	// ListenAddr is the listen address (default :8191).
+	ListenAddr string
+
+	// Conn, if set, is an existing connection to serve on, instead of
+	// listening on ListenAddr.
+	Conn net.PacketConn
+}
+
+// Run runs the server until ctx is done. It returns nil after ctx is done, or
+// an error if the server couldn't be started or failed.
+func (s *ProbeServer) Run(ctx context.Context) (err error) {
+	if s.ListenAddr == "" {
+		s.ListenAddr = DefaultProbeListenAddr
+	}
+	if s.Conn == nil {
+		if s.Conn, err = net.ListenPacket("udp", s.ListenAddr); err != nil {
+			return
+		}
+	}
+	log.Printf("probe server listening on %s", s.Conn.LocalAddr())
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Conn.Close()
+		case <-done:
+		}
+	}()
+
+	b := make([]byte, 64*1024)
+	for {
+		var n int
+		var a net.Addr
+		if n, a, err = s.Conn.ReadFrom(b); err != nil {
+			if ctx.Err() != nil {
+				err = nil
+			}
+			return
+		}
+		if _, err = s.Conn.WriteTo(b[:n], a); err != nil {
+			log.Printf("probe write error: '%s'", err)
+		}
+	}
+}
+
+// Probe contains the parameters for the latency prober.
+type Probe struct {
+	// Addr is the probe server address, with the port defaulting to
+	// DefaultProbePort.
+	Addr string
+
+	// Interval is the interval between probe packets (default
+	// DefaultProbeInterval).
+	Interval time.Duration
+
+	// Size is the probe packet size, in bytes of UDP payload (default
+	// DefaultProbeSize).
+	Size int
+
+	// Duration is how long to send probes. If zero, they're sent until the
+	// context is done.
+	Duration time.Duration
+
+	// Timeout is how long to wait for outstanding replies after sending
+	// stops (default DefaultProbeTimeout).
+	Timeout time.Duration
+}
+
+// init sets defaults and validates the parameters.
+func (p *Probe) init() (err error) {
+	if p.Addr == "" {
+		p.Addr = DefaultAddr
+	}
+	if !strings.Contains(p.Addr, ":") {
+		p.Addr = fmt.Sprintf("%s:%d", p.Addr, DefaultProbePort)
+	}
+	if p.Interval == 0 {
+		p.Interval = DefaultProbeInterval
+	}
+	if p.Size == 0 {
+		p.Size = DefaultProbeSize
+	}
+	if p.Timeout == 0 {
+		p.Timeout = DefaultProbeTimeout
+	}
+	if p.Interval < 0 {
+		err = fmt.Errorf("invalid probe interval: %s", p.Interval)
+		return
+	}
+	if p.Size < MinProbeSize {
+		err = fmt.Errorf("probe size must be at least %d", MinProbeSize)
+		return
+	}
+	return
+}
+
+// Run sends probes until Duration elapses or ctx is done, waits up to Timeout
+// for outstanding replies, and returns the samples in sequence order.
+func (p *Probe) Run(ctx context.Context) (samples []ProbeSample, err error) {
+	if err = p.init(); err != nil {
+		return
+	}
+	var c net.Conn
+	if c, err = net.Dial("udp", p.Addr); err != nil {
+		return
+	}
+	defer c.Close()
+	if p.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Duration)
+		defer cancel()
+	}
+
+	start := time.Now()
+	var mtx sync.Mutex
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b := make([]byte, p.Size)
+		for {
+			n, e := c.Read(b)
+			now := time.Since(start)
+			if errors.Is(e, net.ErrClosed) {
+				return
+			} else if e != nil {
+				continue
+			}
+			if n < MinProbeSize {
+				continue
+			}
+			seq := binary.BigEndian.Uint64(b)
+			sent := time.Duration(binary.BigEndian.Uint64(b[8:]))
+			mtx.Lock()
+			if seq < uint64(len(samples)) && samples[seq].RTT == 0 {
+				samples[seq].RTT = now - sent
+			}
+			mtx.Unlock()
+		}
+	}()
+
+	b := make([]byte, p.Size)
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+	for seq := uint64(0); ; seq++ {
+		now := time.Now()
+		binary.BigEndian.PutUint64(b, seq)
+		binary.BigEndian.PutUint64(b[8:], uint64(now.Sub(start)))
+		mtx.Lock()
+		samples = append(samples, ProbeSample{Seq: seq, Time: now})
+		mtx.Unlock()
+		// unsent probes are counted as lost
+		c.Write(b)
+		select {
+		case <-ctx.Done():
+		case <-t.C:
+			continue
+		}
+		break
+	}
+
+	// wait for outstanding replies, then stop the reader
+	time.Sleep(p.Timeout)
+	c.Close()
+	<-done
+	return
+}
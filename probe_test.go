@@ -0,0 +1,115 @@
+package ccafct
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startProbeServer starts a ProbeServer on a loopback address, which is
+// stopped when the test ends, and returns its address.
+func startProbeServer(t *testing.T) string {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- (&ProbeServer{Conn: c}).Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("probe server: %s", err)
+		}
+	})
+	return c.LocalAddr().String()
+}
+
+func TestProbeEcho(t *testing.T) {
+	p := Probe{
+		Addr:     startProbeServer(t),
+		Interval: 10 * time.Millisecond,
+		Duration: 100 * time.Millisecond,
+		Timeout:  100 * time.Millisecond,
+	}
+	samples, err := p.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) < 5 {
+		t.Fatalf("%d probes sent, want at least 5", len(samples))
+	}
+	for i, s := range samples {
+		if s.Seq != uint64(i) {
+			t.Errorf("sample %d has sequence number %d", i, s.Seq)
+		}
+		if s.Lost() {
+			t.Errorf("probe %d was lost on loopback", s.Seq)
+		}
+		if i > 0 && s.Time.Before(samples[i-1].Time) {
+			t.Errorf("probe %d sent before probe %d", s.Seq, i-1)
+		}
+	}
+}
+
+func TestProbeInvalid(t *testing.T) {
+	for _, p := range []Probe{
+		{Addr: "127.0.0.1:0", Size: MinProbeSize - 1},
+		{Addr: "127.0.0.1:0", Interval: -time.Millisecond},
+	} {
+		if _, err := p.Run(context.Background()); err == nil {
+			t.Errorf("Run accepted %+v", p)
+		}
+	}
+}
+
+func TestAnalyzeProbe(t *testing.T) {
+	t0 := time.Now()
+	var samples []ProbeSample
+	for i, r := range []time.Duration{10, 40, 0, 10, 40} {
+		samples = append(samples, ProbeSample{
+			Seq:  uint64(i),
+			Time: t0.Add(time.Duration(i) * 10 * time.Millisecond),
+			RTT:  r * time.Millisecond,
+		})
+	}
+	s := AnalyzeProbe(samples)
+	if s.Sent != 5 {
+		t.Errorf("sent %d, want 5", s.Sent)
+	}
+	if s.Loss != 0.2 {
+		t.Errorf("loss %f, want 0.2", s.Loss)
+	}
+	// the lost probe is skipped, so each difference is 30ms
+	if j := time.Duration(s.Jitter); j != 30*time.Millisecond {
+		t.Errorf("jitter %s, want 30ms", j)
+	}
+	if m := time.Duration(s.Median.Duration); m != 10*time.Millisecond {
+		t.Errorf("median %s, want 10ms", m)
+	}
+	if p := time.Duration(s.P99.Duration); p != 40*time.Millisecond {
+		t.Errorf("p99 %s, want 40ms", p)
+	}
+
+	// start is exclusive and end inclusive
+	b := ProbeSamplesBetween(samples, t0, t0.Add(20*time.Millisecond))
+	if len(b) != 2 || b[0].Seq != 1 || b[1].Seq != 2 {
+		t.Errorf("samples between %+v, want sequence numbers 1 and 2", b)
+	}
+
+	solo := AnalyzeProbe([]ProbeSample{
+		{Seq: 0, RTT: 10 * time.Millisecond},
+		{Seq: 1, RTT: 20 * time.Millisecond},
+	})
+	s.SetHarm(solo)
+	if h := s.Median.Harm; !h.Zero() {
+		t.Errorf("median harm %s, want zero", h)
+	}
+	if h := s.P99.Harm; h != 0.5 {
+		t.Errorf("p99 harm %s, want 0.500", h)
+	}
+}
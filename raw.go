@@ -0,0 +1,437 @@
+package ccafct
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/heistp/fct/bitrate"
+	"github.com/heistp/fct/unit"
+)
+
+// The raw protocol is a minimal alternative to HTTP. For each flow, the client
+// opens a TCP connection and sends a fixed size request header:
+//
+//	magic    [2]byte  "FC"
+//	version  uint8    rawVersion
+//	flags    uint8    rawFlagUpload, or zero
+//	length   uint64   requested flow length
+//	cca      [16]byte congestion control algorithm, NUL padded
+//	tos      uint8    IP TOS byte
+//	reserved [3]byte  zero
+//	maxrate  uint64   maximum pacing rate, in bits per second
+//	sndbuf   uint32   send buffer size
+//	rcvbuf   uint32   receive buffer size
+//	lowat    uint32   TCP_NOTSENT_LOWAT
+//	id       uint64   flow ID, or zero
+//
+// with all integers big endian, and zero socket options not set. Reserved
+// fields are ignored by the server, and leave room for request options without
+// changing the header length.
+//
+// For uploads, the header is followed by length payload bytes from the client.
+//
+// The server replies with a status byte. If the status is rawStatusOK, it is
+// followed by length payload bytes for downloads, or none for uploads, then a
+// uint32 big endian trailer length and the trailer, which is the server's
+// ServerFlow record in JSON. For uploads, the status is sent after the payload
+// is received, and is the acknowledgement. If the status is not rawStatusOK,
+// it is followed by a uint16 big endian message length and an error message,
+// and the connection is closed.
+
+// rawMagic identifies raw protocol requests.
+var rawMagic = [2]byte{'F', 'C'}
+
+// rawVersion is the raw protocol version.
+const rawVersion = 1
+
+// rawCCALen is the length of the CCA field in the raw request header.
+const rawCCALen = 16
+
+// rawMaxTrailerLen is the maximum accepted raw trailer length.
+const rawMaxTrailerLen = 64 * 1024
+
+// rawFlagUpload is the request header flag for upload flows.
+const rawFlagUpload = 0x01
+
+// Raw response status values.
+const (
+	rawStatusOK    = 0
+	rawStatusError = 1
+)
+
+// rawHeader is the raw protocol request header.
+type rawHeader struct {
+	Magic    [2]byte
+	Version  uint8
+	Flags    uint8
+	Length   uint64
+	CCA      [rawCCALen]byte
+	TOS      uint8
+	Reserved [3]byte
+	MaxRate  uint64
+	SndBuf   uint32
+	RcvBuf   uint32
+	Lowat    uint32
+	ID       uint64
+}
+
+// newRawHeader returns a new rawHeader with the flow ID and server's socket
+// options.
+func newRawHeader(id uint64, length int, o SockOpts, upload bool) (
+	h rawHeader, err error) {
+	if len(o.CCA) > rawCCALen {
+		err = fmt.Errorf("CCA name too long for raw protocol: '%s'", o.CCA)
+		return
+	}
+	h.Magic = rawMagic
+	h.Version = rawVersion
+	h.Length = uint64(length)
+	if upload {
+		h.Flags |= rawFlagUpload
+	}
+	copy(h.CCA[:], o.CCA)
+	h.TOS = uint8(o.TOS)
+	h.MaxRate = uint64(o.MaxPacingRate)
+	h.SndBuf = uint32(o.SndBuf)
+	h.RcvBuf = uint32(o.RcvBuf)
+	h.Lowat = uint32(o.NotSentLowat)
+	h.ID = id
+	return
+}
+
+// sockOpts returns the socket options in the header.
+func (h rawHeader) sockOpts() SockOpts {
+	return SockOpts{
+		CCA:           string(bytes.TrimRight(h.CCA[:], "\x00")),
+		TOS:           int(h.TOS),
+		MaxPacingRate: bitrate.Bitrate(h.MaxRate),
+		SndBuf:        int(h.SndBuf),
+		RcvBuf:        int(h.RcvBuf),
+		NotSentLowat:  int(h.Lowat),
+	}
+}
+
+// validate returns an error if the header is invalid, or doesn't have the
+// given magic.
+func (h rawHeader) validate(magic [2]byte) error {
+	if h.Magic != magic {
+		return fmt.Errorf("invalid raw magic: %v", h.Magic)
+	}
+	if h.Version != rawVersion {
+		return fmt.Errorf("unsupported raw version: %d", h.Version)
+	}
+	if h.Flags&^rawFlagUpload != 0 {
+		return fmt.Errorf("unknown raw flags: %#x", h.Flags)
+	}
+	if int64(h.Length) < 0 {
+		return fmt.Errorf("invalid raw flow length: %d", h.Length)
+	}
+	return h.sockOpts().Validate()
+}
+
+// acceptRetry returns how long to wait before retrying Accept after err, given
+// the last delay, or zero if err isn't temporary. Like net/http.Server, the
+// delay starts at 5ms and doubles up to 1s.
+func acceptRetry(err error, last time.Duration) time.Duration {
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Temporary() {
+		return 0
+	}
+	if last == 0 {
+		return 5 * time.Millisecond
+	}
+	if last *= 2; last > time.Second {
+		last = time.Second
+	}
+	return last
+}
+
+// serveRaw accepts raw protocol connections until the listener is closed. It
+// returns nil if the server was shut down. Temporary Accept errors, e.g.
+// EMFILE, are logged and retried.
+func (s *Server) serveRaw(l net.Listener) error {
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		accept := time.Now()
+		if err != nil {
+			s.mtx.Lock()
+			closed := s.closed
+			s.mtx.Unlock()
+			if closed {
+				return nil
+			}
+			if delay = acceptRetry(err, delay); delay > 0 {
+				log.Printf("raw accept error: '%s', retrying in %s", err,
+					delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		tc, ok := c.(*net.TCPConn)
+		if !ok {
+			log.Printf("raw conn not TCP: '%v'", c)
+			c.Close()
+			continue
+		}
+		if !s.trackRaw(tc, true) {
+			tc.Close()
+			continue
+		}
+		go func() {
+			defer s.trackRaw(tc, false)
+			s.handleRaw(tc, accept)
+		}()
+	}
+}
+
+// trackRaw adds or removes an active raw connection, and updates rawWG. It
+// returns false if the connection can't be added because the server was shut
+// down.
+func (s *Server) trackRaw(c net.Conn, add bool) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !add {
+		delete(s.rawConns, c)
+		s.rawWG.Done()
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.rawConns[c] = struct{}{}
+	s.rawWG.Add(1)
+	return true
+}
+
+// handleRaw handles one raw protocol connection.
+func (s *Server) handleRaw(c *net.TCPConn, accept time.Time) {
+	defer c.Close()
+
+	cpu0 := s.lockThread()
+	defer s.unlockThread()
+
+	var h rawHeader
+	var err error
+	f := &ServerFlow{Accept: accept}
+	if err = binary.Read(c, binary.BigEndian, &h); err != nil {
+		log.Printf("raw read error: '%s'", err)
+		s.endFlow(f, cpu0, err)
+		return
+	}
+	f.ID = h.ID
+	f.Request = time.Now()
+	if err = h.validate(rawMagic); err != nil {
+		s.endFlow(f, cpu0, err)
+		rawError(c, err)
+		return
+	}
+
+	if err = applySockOpts(h.sockOpts(), func(f func(fd int) error) error {
+		return connControl(c, f)
+	}); err != nil {
+		s.endFlow(f, cpu0, err)
+		rawError(c, err)
+		return
+	}
+
+	upload := h.Flags&rawFlagUpload != 0
+	if upload {
+		if _, err = io.CopyN(recordWriter{f}, c,
+			int64(h.Length)); err != nil {
+			log.Printf("raw read error after %d bytes: '%s'", f.Bytes, err)
+			s.endFlow(f, cpu0, err)
+			return
+		}
+	}
+	if _, err = c.Write([]byte{rawStatusOK}); err != nil {
+		log.Printf("raw write error: '%s'", err)
+		s.endFlow(f, cpu0, err)
+		return
+	}
+	if !upload && s.zc != nil {
+		if err = s.zc.send(c, int64(h.Length), f); err != nil {
+			log.Printf("raw write error: '%s'", err)
+			s.endFlow(f, cpu0, err)
+			return
+		}
+	}
+	var n int
+	for r := int64(h.Length); r > 0 && !upload && s.zc == nil; r -= int64(n) {
+		l := s.BufLen
+		if r < int64(s.BufLen) {
+			l = int(r)
+		}
+		n, err = c.Write(s.buf[:l])
+		f.record(n)
+		if err != nil {
+			log.Printf("raw write error: '%s'", err)
+			s.endFlow(f, cpu0, err)
+			return
+		}
+	}
+
+	var info TCPInfo
+	if err = connControl(c, func(fd int) (err error) {
+		info, err = GetTCPInfo(fd)
+		return
+	}); err != nil {
+		log.Printf("unable to get TCP_INFO: '%s'", err)
+	} else {
+		f.TCPInfo = &info
+	}
+	s.endFlow(f, cpu0, nil)
+	var t []byte
+	if t, err = json.Marshal(f); err != nil {
+		log.Printf("unable to marshal server flow: '%s'", err)
+		t = nil
+	}
+	b := make([]byte, 4, 4+len(t))
+	binary.BigEndian.PutUint32(b, uint32(len(t)))
+	if _, err = c.Write(append(b, t...)); err != nil {
+		log.Printf("raw write error: '%s'", err)
+	}
+}
+
+// rawError sends an error response to a raw client.
+func rawError(c net.Conn, err error) {
+	m := err.Error()
+	if len(m) > 0xffff {
+		m = m[:0xffff]
+	}
+	b := []byte{rawStatusError, 0, 0}
+	binary.BigEndian.PutUint16(b[1:], uint16(len(m)))
+	if _, werr := c.Write(append(b, m...)); werr != nil {
+		log.Printf("raw write error: '%s'", werr)
+	}
+}
+
+// readRawError reads the message of an error response from a raw server, and
+// returns it as an error.
+func readRawError(r io.Reader) (err error) {
+	var l uint16
+	if err = binary.Read(r, binary.BigEndian, &l); err != nil {
+		return
+	}
+	m := make([]byte, l)
+	if _, err = io.ReadFull(r, m); err != nil {
+		return
+	}
+	err = fmt.Errorf("client received: %s", m)
+	return
+}
+
+// connControl calls f with the file descriptor of a TCP connection.
+func connControl(c *net.TCPConn, f func(fd int) error) (err error) {
+	var rc syscall.RawConn
+	if rc, err = c.SyscallConn(); err != nil {
+		return
+	}
+	if cerr := rc.Control(func(fd uintptr) {
+		err = f(int(fd))
+	}); cerr != nil {
+		err = cerr
+	}
+	return
+}
+
+// doRawRequest runs one flow using the raw protocol.
+func (t *Test) doRawRequest(ctx context.Context, id uint64, reqLen int,
+	cca string) (flow Flow, err error) {
+	flow.Upload = t.Direction == Upload
+	o := t.ServerSockOpts
+	o.CCA = cca
+	var h rawHeader
+	if h, err = newRawHeader(id, reqLen, o, flow.Upload); err != nil {
+		return
+	}
+
+	flow.Start = time.Now()
+
+	var c net.Conn
+	flow.ConnectStart = time.Now()
+	if c, err = t.dialer(cca).DialContext(ctx, "tcp", t.Addr); err != nil {
+		return
+	}
+	flow.Connected = time.Now()
+	defer c.Close()
+
+	// close the connection if the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+
+	if err = binary.Write(c, binary.BigEndian, &h); err != nil {
+		return
+	}
+	if flow.Upload {
+		if _, err = io.Copy(c, zeros(reqLen)); err != nil {
+			return
+		}
+	}
+	flow.WroteRequest = time.Now()
+
+	br := bufio.NewReaderSize(c, DefaultBufLen)
+	var status byte
+	if status, err = br.ReadByte(); err != nil {
+		return
+	}
+	flow.FirstByte = time.Now()
+	if status != rawStatusOK {
+		err = readRawError(br)
+		return
+	}
+
+	if flow.Upload {
+		flow.Length = unit.Bytes(reqLen)
+		flow.ClientTCPInfo = clientTCPInfo(c)
+	} else {
+		var n int64
+		n, err = io.CopyN(io.Discard, br, int64(reqLen))
+		flow.Length = unit.Bytes(n)
+		if err != nil {
+			return
+		}
+	}
+	flow.End = time.Now()
+
+	var tl uint32
+	if err = binary.Read(br, binary.BigEndian, &tl); err != nil {
+		return
+	}
+	if tl > rawMaxTrailerLen {
+		err = fmt.Errorf("raw trailer too long: %d", tl)
+		return
+	}
+	if tl > 0 {
+		b := make([]byte, tl)
+		if _, err = io.ReadFull(br, b); err != nil {
+			return
+		}
+		sf := new(ServerFlow)
+		if err = json.Unmarshal(b, sf); err != nil {
+			return
+		}
+		flow.Server = sf
+	}
+
+	return
+}
@@ -0,0 +1,180 @@
+package ccafct
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/heistp/fct/bitrate"
+)
+
+func TestRawHeaderLayout(t *testing.T) {
+	if n := binary.Size(rawHeader{}); n != 60 {
+		t.Errorf("raw header length %d, want 60", n)
+	}
+	o := SockOpts{
+		CCA:           "cubic",
+		TOS:           0xb8,
+		MaxPacingRate: 100 * bitrate.Mbps,
+		SndBuf:        1 << 20,
+		RcvBuf:        1 << 21,
+		NotSentLowat:  16384,
+	}
+	h, err := newRawHeader(7, 1234, o, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err = binary.Write(&b, binary.BigEndian, &h); err != nil {
+		t.Fatal(err)
+	}
+	var r rawHeader
+	if err = binary.Read(&b, binary.BigEndian, &r); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.validate(rawMagic); err != nil {
+		t.Error(err)
+	}
+	if ro := r.sockOpts(); ro != o {
+		t.Errorf("socket options %s, want %s", ro, o)
+	}
+	if r.ID != 7 || r.Length != 1234 {
+		t.Errorf("ID %d and length %d, want 7 and 1234", r.ID, r.Length)
+	}
+	if h.Flags != rawFlagUpload {
+		t.Errorf("flags %#x, want %#x", h.Flags, rawFlagUpload)
+	}
+	if _, err = newRawHeader(7, 1234, SockOpts{
+		CCA: strings.Repeat("x", rawCCALen+1),
+	}, false); err == nil {
+		t.Error("newRawHeader accepted a CCA name that's too long")
+	}
+	v := h
+	v.Version++
+	if err = v.validate(rawMagic); err == nil {
+		t.Errorf("validate accepted version %d", v.Version)
+	}
+	f := h
+	f.Flags |= 0x80
+	if err = f.validate(rawMagic); err == nil {
+		t.Errorf("validate accepted flags %#x", f.Flags)
+	}
+}
+
+func TestRawFlows(t *testing.T) {
+	tst, err := NewTest(Params{
+		Addr:        startServer(t).RawListener.Addr().String(),
+		Protocol:    ProtocolTCP,
+		ReplayTrace: writeTrace(t, "0,100000\n0.01,1\n0.02,0\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tst.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lengths []int
+	for _, f := range data.Flow {
+		lengths = append(lengths, int(f.Length))
+		if !f.Timed() {
+			t.Errorf("flow of length %d has no timing breakdown", f.Length)
+		}
+		if f.Server == nil || f.Server.TCPInfo == nil {
+			t.Errorf("flow of length %d has no TCP_INFO", f.Length)
+		}
+	}
+	sort.Ints(lengths)
+	if want := []int{0, 1, 100000}; !reflect.DeepEqual(lengths, want) {
+		t.Errorf("flow lengths %v, want %v", lengths, want)
+	}
+}
+
+// failingListener is a net.Listener whose first fails Accepts return EMFILE.
+type failingListener struct {
+	net.Listener
+	fails int
+}
+
+// newFailingListener returns a loopback failingListener.
+func newFailingListener(t *testing.T, fails int) *failingListener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &failingListener{l, fails}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.fails > 0 {
+		l.fails--
+		return nil, &net.OpError{Op: "accept", Net: "tcp",
+			Addr: l.Addr(), Err: syscall.EMFILE}
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptRetry(t *testing.T) {
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	var d time.Duration
+	for _, want := range []time.Duration{5 * time.Millisecond,
+		10 * time.Millisecond, 20 * time.Millisecond} {
+		if d = acceptRetry(emfile, d); d != want {
+			t.Errorf("retry delay %s, want %s", d, want)
+		}
+	}
+	if d = acceptRetry(emfile, 800*time.Millisecond); d != time.Second {
+		t.Errorf("retry delay %s, want the 1s maximum", d)
+	}
+	if d = acceptRetry(errors.New("closed"), d); d != 0 {
+		t.Errorf("retry delay %s for a permanent error, want 0", d)
+	}
+}
+
+func TestRawAcceptRetry(t *testing.T) {
+	s := runServer(t, &Server{RawListener: newFailingListener(t, 3)})
+	tst, err := NewTest(Params{
+		Addr:        s.RawListener.Addr().String(),
+		Protocol:    ProtocolTCP,
+		ReplayTrace: writeTrace(t, "0,1000\n0.01,1000\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tst.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(data.Flow); n != 2 {
+		t.Errorf("%d flows completed, want 2", n)
+	}
+}
+
+func TestRawServerError(t *testing.T) {
+	tst, err := NewTest(Params{
+		Addr:     startServer(t).RawListener.Addr().String(),
+		Protocol: ProtocolTCP,
+		CCA:      "nosuchcca",
+		ClientSockOpts: SockOpts{
+			CCA: "reno",
+		},
+		ReplayTrace: writeTrace(t, "0,1000\n0.01,1000\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ccaError("nosuchcca").Error()
+	if _, err = tst.Run(context.Background()); err == nil ||
+		!strings.Contains(err.Error(), want) {
+		t.Errorf("Run error '%v', want '%s'", err, want)
+	}
+}
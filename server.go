@@ -2,37 +2,93 @@ package ccafct
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
-	"os"
+	"runtime"
 	"strconv"
-
-	"golang.org/x/sys/unix"
+	"sync"
+	"time"
 )
 
 // DefaultListenAddr is the default listen address.
 var DefaultListenAddr = fmt.Sprintf(":%d", DefaultPort)
 
+// DefaultRawListenAddr is the default listen address for the raw protocol.
+var DefaultRawListenAddr = fmt.Sprintf(":%d", DefaultRawPort)
+
 // DefaultBufLen is the default buffer length.
 const DefaultBufLen = 32 * 1024
 
+// DefaultShutdownTimeout is how long Run waits for active flows to complete
+// when its context is done.
+var DefaultShutdownTimeout = 5 * time.Second
+
 // ctxKey is a custom type for context keys.
 type ctxKey string
 
 // connCtxKey is the net.Conn context key.
 const connCtxKey = ctxKey("net.Conn")
 
-// Server is the FCT server.
+// acceptCtxKey is the context key for the connection's accept time.
+const acceptCtxKey = ctxKey("accept")
+
+// Server is the FCT server. Each Server has its own ServeMux, so multiple
+// Servers may run in one process.
 type Server struct {
 	// ListenAddr is the server listen address (default :8188)
 	ListenAddr string
 
+	// RawListenAddr is the listen address for the raw protocol (default
+	// :8189). If "-", the raw protocol is disabled.
+	RawListenAddr string
+
+	// Listener, if set, is an existing listener to serve HTTP on, instead of
+	// listening on ListenAddr.
+	Listener net.Listener
+
+	// RawListener, if set, is an existing listener to serve the raw protocol
+	// on, instead of listening on RawListenAddr.
+	RawListener net.Listener
+
 	// BufLen is the length of the buffer for writing responses.
 	BufLen int
 
-	buf []byte
+	// ZeroCopy, if true, sends raw protocol download payloads with
+	// sendfile(2) from a memory-backed file, instead of copying them from a
+	// buffer, to reduce server CPU use at high rates. HTTP responses are
+	// unaffected.
+	ZeroCopy bool
+
+	// ZeroCopyLen is the length of the memory-backed file for ZeroCopy
+	// (default DefaultZeroCopyLen).
+	ZeroCopyLen int
+
+	// FlowLog, if set, receives a ServerFlow record for each flow, as NDJSON.
+	// Records are written for flows that fail, which the client doesn't
+	// receive.
+	FlowLog io.Writer
+
+	// FlowCPU, if true, locks each flow's handler to an OS thread, so the
+	// flow's CPU time can be recorded in ServerFlow.CPU. This uses a thread
+	// per active flow, so it's off by default.
+	FlowCPU bool
+
+	// ShutdownTimeout is how long Run waits for active flows to complete
+	// when its context is done (default DefaultShutdownTimeout).
+	ShutdownTimeout time.Duration
+
+	buf      []byte
+	zc       *zeroCopySource
+	flowLog  *flowLog
+	http     *http.Server
+	rawConns map[net.Conn]struct{}
+	rawWG    sync.WaitGroup
+	closed   bool
+	mtx      sync.Mutex
 }
 
 // init initializes the Server struct.
@@ -41,52 +97,209 @@ func (s *Server) init() {
 		s.ListenAddr = DefaultListenAddr
 	}
 
+	if s.RawListenAddr == "" {
+		s.RawListenAddr = DefaultRawListenAddr
+	}
+
 	if s.BufLen == 0 {
 		s.BufLen = DefaultBufLen
 	}
 
-	s.buf = make([]byte, s.BufLen)
-}
+	if s.ZeroCopyLen == 0 {
+		s.ZeroCopyLen = DefaultZeroCopyLen
+	}
 
-// Run runs the server.
-func (s *Server) Run() error {
-	s.init()
+	if s.ShutdownTimeout == 0 {
+		s.ShutdownTimeout = DefaultShutdownTimeout
+	}
 
-	http.HandleFunc(FCTPath, s.handleFCT)
+	s.buf = make([]byte, s.BufLen)
 
-	server := http.Server{
-		Addr: s.ListenAddr,
+	mux := http.NewServeMux()
+	mux.HandleFunc(FCTPath, s.handleFCT)
+	s.http = &http.Server{
+		Handler: mux,
 		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			ctx = context.WithValue(ctx, acceptCtxKey, time.Now())
 			return context.WithValue(ctx, connCtxKey, c)
 		},
 	}
+	s.rawConns = make(map[net.Conn]struct{})
+	if s.FlowLog != nil {
+		s.flowLog = &flowLog{enc: json.NewEncoder(s.FlowLog)}
+	}
+}
 
-	log.Printf("server listening on %s", s.ListenAddr)
+// listen creates any listeners that weren't given.
+func (s *Server) listen() (err error) {
+	if s.Listener == nil {
+		if s.Listener, err = net.Listen("tcp", s.ListenAddr); err != nil {
+			return
+		}
+	}
+	if s.RawListener == nil && s.RawListenAddr != "-" {
+		if s.RawListener, err = net.Listen("tcp",
+			s.RawListenAddr); err != nil {
+			s.Listener.Close()
+			return
+		}
+	}
+	return
+}
+
+// Run runs the server until ctx is done, then shuts it down, waiting up to
+// ShutdownTimeout for active flows to complete. It returns nil after a
+// shutdown, or an error if the server couldn't be started or failed. A Server
+// may only be run once.
+func (s *Server) Run(ctx context.Context) (err error) {
+	s.mtx.Lock()
+	if s.http != nil || s.closed {
+		s.mtx.Unlock()
+		return fmt.Errorf("server already run or shut down")
+	}
+	s.init()
+	if s.ZeroCopy {
+		if s.zc, err = newZeroCopySource(s.ZeroCopyLen); err != nil {
+			s.mtx.Unlock()
+			return
+		}
+		defer s.zc.Close()
+	}
+	if err = s.listen(); err != nil {
+		s.mtx.Unlock()
+		return
+	}
+	s.mtx.Unlock()
 
-	return server.ListenAndServe()
+	errCh := make(chan error, 2)
+	if s.RawListener != nil {
+		log.Printf("raw server listening on %s", s.RawListener.Addr())
+		go func() {
+			errCh <- s.serveRaw(s.RawListener)
+		}()
+	}
+	log.Printf("server listening on %s", s.Listener.Addr())
+	go func() {
+		if e := s.http.Serve(s.Listener); e != http.ErrServerClosed {
+			errCh <- e
+			return
+		}
+		errCh <- nil
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+	}
+	sctx, cancel := context.WithTimeout(context.Background(),
+		s.ShutdownTimeout)
+	defer cancel()
+	if serr := s.Shutdown(sctx); serr != nil && err == nil {
+		err = serr
+	}
+	return
+}
+
+// Shutdown gracefully shuts down the server, closing its listeners and
+// waiting for active flows to complete. If ctx is done first, the remaining
+// connections are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) (err error) {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return
+	}
+	s.closed = true
+	h := s.http
+	s.mtx.Unlock()
+	if h == nil {
+		return
+	}
+
+	if s.RawListener != nil {
+		s.RawListener.Close()
+	}
+	if err = h.Shutdown(ctx); err != nil {
+		h.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.rawWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.mtx.Lock()
+		for c := range s.rawConns {
+			c.Close()
+		}
+		s.mtx.Unlock()
+		<-done
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return
 }
 
 // handleFCT is the HandleFunc for the FCT request path.
 func (s *Server) handleFCT(w http.ResponseWriter, r *http.Request) {
+	cpu0 := s.lockThread()
+	defer s.unlockThread()
+
 	var flen int64
 	var fstr string
 	var err error
 
+	f := &ServerFlow{Request: time.Now()}
+	if a, ok := r.Context().Value(acceptCtxKey).(time.Time); ok {
+		f.Accept = a
+	}
+	if id := r.Header.Get(FlowIDHeader); id != "" {
+		if f.ID, err = strconv.ParseUint(id, 10, 64); err != nil {
+			s.badRequest(w, f, cpu0,
+				fmt.Errorf("invalid %s: '%s'", FlowIDHeader, id))
+			return
+		}
+	}
+
 	if err = s.setSockOpts(r); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.badRequest(w, f, cpu0, err)
 		return
 	}
 
 	if fstr = r.Header.Get(FlowLengthHeader); fstr == "" {
-		http.Error(w, fmt.Sprintf("missing '%s' header", FlowLengthHeader),
-			http.StatusBadRequest)
+		s.badRequest(w, f, cpu0,
+			fmt.Errorf("missing '%s' header", FlowLengthHeader))
 		return
 	}
 
 	if flen, err = strconv.ParseInt(fstr, 10, 64); err != nil || flen < 0 {
-		http.Error(w,
-			fmt.Sprintf("invalid %s: '%s'", FlowLengthHeader, fstr),
-			http.StatusBadRequest)
+		s.badRequest(w, f, cpu0,
+			fmt.Errorf("invalid %s: '%s'", FlowLengthHeader, fstr))
+		return
+	}
+
+	w.Header().Set("Trailer", ServerFlowHeader)
+
+	if r.Method == http.MethodPost {
+		if _, err = io.Copy(recordWriter{f}, r.Body); err != nil {
+			log.Printf("read error: '%s'", err.Error())
+			s.endFlow(f, cpu0, err)
+			http.Error(w, fmt.Sprintf("read error: %s", err),
+				http.StatusBadRequest)
+			return
+		}
+		if f.Bytes != flen {
+			err = fmt.Errorf("received %d bytes, expected %d", f.Bytes, flen)
+			s.endFlow(f, cpu0, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		s.setTrailer(w, r, f, cpu0)
 		return
 	}
 
@@ -96,54 +309,132 @@ func (s *Server) handleFCT(w http.ResponseWriter, r *http.Request) {
 		if r < int64(s.BufLen) {
 			l = int(r)
 		}
-		if n, err = w.Write(s.buf[:l]); err != nil {
+		n, err = w.Write(s.buf[:l])
+		f.record(n)
+		if err != nil {
 			log.Printf("write error: '%s'", err.Error())
-			break
+			s.endFlow(f, cpu0, err)
+			return
 		}
 	}
+	// flush the ResponseWriter's buffer, so LastByte and TCP_INFO cover the
+	// whole payload
+	if fl, ok := w.(http.Flusher); ok {
+		fl.Flush()
+		if flen > 0 {
+			f.LastByte = time.Now()
+		}
+	}
+
+	s.setTrailer(w, r, f, cpu0)
 }
 
-// setSockOpts sets socket options for the request. Detailed error information
-// is logged, while the error returned is suitable for sending to the client.
-func (s *Server) setSockOpts(r *http.Request) (err error) {
-	var tcpConn *net.TCPConn
-	var ok bool
-	var f *os.File
-	var cca string
+// badRequest ends a flow with an error, and sends the error to the client.
+func (s *Server) badRequest(w http.ResponseWriter, f *ServerFlow,
+	cpu0 time.Duration, err error) {
+	s.endFlow(f, cpu0, err)
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
 
-	if cca = r.Header.Get(CCAHeader); cca == "" {
+// setTrailer completes the ServerFlow record with the request socket's
+// TCP_INFO, logs it and sets it in the ServerFlowHeader trailer. Errors are
+// logged, and the trailer is omitted.
+func (s *Server) setTrailer(w http.ResponseWriter, r *http.Request,
+	f *ServerFlow, cpu0 time.Duration) {
+	var info TCPInfo
+	if err := sockControl(r, func(fd int) (err error) {
+		info, err = GetTCPInfo(fd)
+		return
+	}); err != nil {
+		log.Printf("unable to get TCP_INFO: '%s'", err)
+	} else {
+		f.TCPInfo = &info
+	}
+	s.endFlow(f, cpu0, nil)
+	b, err := json.Marshal(f)
+	if err != nil {
+		log.Printf("unable to marshal server flow: '%s'", err)
 		return
 	}
+	w.Header().Set(ServerFlowHeader, string(b))
+}
+
+// endFlow sets the CPU time since cpu0, if FlowCPU is true, and any error in a
+// ServerFlow record, and logs it.
+func (s *Server) endFlow(f *ServerFlow, cpu0 time.Duration, err error) {
+	if s.FlowCPU {
+		f.CPU = threadCPU() - cpu0
+	}
+	if err != nil {
+		f.Error = err.Error()
+	}
+	s.flowLog.log(f)
+}
+
+// lockThread locks the calling goroutine to its thread if FlowCPU is true, so
+// the thread's CPU time is the flow's, and returns the thread's CPU time so
+// far. It returns zero if FlowCPU is false.
+func (s *Server) lockThread() time.Duration {
+	if !s.FlowCPU {
+		return 0
+	}
+	runtime.LockOSThread()
+	return threadCPU()
+}
+
+// unlockThread unlocks the calling goroutine from its thread if FlowCPU is
+// true.
+func (s *Server) unlockThread() {
+	if s.FlowCPU {
+		runtime.UnlockOSThread()
+	}
+}
 
+// sockControl calls f with the file descriptor of the request's TCP socket.
+func sockControl(r *http.Request, f func(fd int) error) (err error) {
+	var tcpConn *net.TCPConn
+	var ok bool
 	conn := r.Context().Value(connCtxKey)
 	if tcpConn, ok = conn.(*net.TCPConn); !ok {
-		log.Printf("setSockOpts request not tcpConn: '%v'", conn)
-		err = ccaError(cca)
-		return
-	}
-	if f, err = tcpConn.File(); err != nil {
-		log.Printf("setSockOpts unable to get file: %s", err)
-		err = ccaError(cca)
+		err = fmt.Errorf("request not tcpConn: '%v'", conn)
 		return
 	}
+	return connControl(tcpConn, f)
+}
 
-	fd := int(f.Fd())
-
-	if err = unix.SetsockoptString(fd, unix.IPPROTO_TCP,
-		unix.TCP_CONGESTION, cca); err != nil {
-		log.Printf("setSockOpts unable to set TCP_CONGESTION to '%s': '%s'",
-			cca, err)
-		err = ccaError(cca)
+// setSockOpts sets socket options for the request. Detailed error information
+// is logged, while the error returned is suitable for sending to the client.
+func (s *Server) setSockOpts(r *http.Request) (err error) {
+	var o SockOpts
+	if o, err = sockOptsFromHeader(r.Header); err != nil {
 		return
 	}
-	/*
-		if cca, err = unix.GetsockoptString(fd, unix.IPPROTO_TCP,
-			unix.TCP_CONGESTION); err != nil {
-			log.Printf("setSockOpts unable to get TCP_CONGESTION: '%s'", err)
+	return applySockOpts(o, func(f func(fd int) error) error {
+		return sockControl(r, f)
+	})
+}
+
+// applySockOpts sets socket options using the given control function.
+// Detailed error information is logged, while the error returned is suitable
+// for sending to the client.
+func applySockOpts(o SockOpts, control func(func(fd int) error) error) (
+	err error) {
+	if o.CCA != "" {
+		if err = control(SockOpts{CCA: o.CCA}.Set); err != nil {
+			log.Printf("setSockOpts %s", err)
+			err = ccaError(o.CCA)
 			return
 		}
-	*/
-
+		o.CCA = ""
+	}
+	if o == (SockOpts{}) {
+		return
+	}
+	if err = control(o.Set); err != nil {
+		log.Printf("setSockOpts %s", err)
+		err = fmt.Errorf("unable to set socket options: %s", o)
+		return
+	}
 	return
 }
 
@@ -0,0 +1,334 @@
+package ccafct
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/heistp/fct/bitrate"
+)
+
+// startServer runs a Server on loopback listeners until the test ends.
+func startServer(t *testing.T) *Server {
+	t.Helper()
+	return runServer(t, &Server{})
+}
+
+// runServer runs the given Server on loopback listeners until the test ends,
+// and returns it. Its RawListener is kept, if set.
+func runServer(t *testing.T, s *Server) *Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.RawListener == nil {
+		if s.RawListener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			l.Close()
+			t.Fatal(err)
+		}
+	}
+	s.Listener = l
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %s", err)
+		}
+	})
+	return s
+}
+
+// waitRawConns waits until the server has n active raw connections.
+func waitRawConns(t *testing.T, s *Server, n int) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		s.mtx.Lock()
+		c := len(s.rawConns)
+		s.mtx.Unlock()
+		if c == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d raw connections", n)
+}
+
+func TestTCPInfoTrailer(t *testing.T) {
+	tst, err := NewTest(Params{
+		Addr:        startServer(t).Listener.Addr().String(),
+		ReplayTrace: writeTrace(t, "0,100000\n0.01,1\n0.02,0\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tst.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lengths []int
+	for _, f := range data.Flow {
+		lengths = append(lengths, int(f.Length))
+	}
+	sort.Ints(lengths)
+	if want := []int{0, 1, 100000}; !reflect.DeepEqual(lengths, want) {
+		t.Errorf("flow lengths %v, want %v", lengths, want)
+	}
+	for _, f := range data.Flow {
+		if f.Server == nil || f.Server.TCPInfo == nil {
+			t.Errorf("flow of length %d has no TCP_INFO", f.Length)
+			continue
+		}
+		ti := f.Server.TCPInfo
+		if ti.MinRTT <= 0 {
+			t.Errorf("flow of length %d has min RTT %s", f.Length, ti.MinRTT)
+		}
+		if ti.Cwnd == 0 {
+			t.Errorf("flow of length %d has a zero cwnd", f.Length)
+		}
+		// the payload is flushed before TCP_INFO is read, so on loopback
+		// it has all been sent
+		if ti.BytesSent < uint64(f.Length) {
+			t.Errorf("flow of length %d has only %d bytes sent", f.Length,
+				ti.BytesSent)
+		}
+	}
+}
+
+func TestUploadFlows(t *testing.T) {
+	s := startServer(t)
+	for _, c := range []struct {
+		proto string
+		addr  string
+	}{
+		{ProtocolHTTP, s.Listener.Addr().String()},
+		{ProtocolTCP, s.RawListener.Addr().String()},
+	} {
+		tst, err := NewTest(Params{
+			Addr:        c.addr,
+			Protocol:    c.proto,
+			Direction:   Upload,
+			ReplayTrace: writeTrace(t, "0,100000\n0.01,1\n0.02,0\n"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := tst.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %s", c.proto, err)
+		}
+		var lengths []int
+		for _, f := range data.Flow {
+			if !f.Upload {
+				t.Errorf("%s: flow of length %d isn't an upload", c.proto,
+					f.Length)
+			}
+			lengths = append(lengths, int(f.Length))
+			// the client is the sender, so its TCP_INFO is used
+			ti := f.SenderTCPInfo()
+			if ti == nil || ti != f.ClientTCPInfo {
+				t.Errorf("%s: flow of length %d has no client TCP_INFO",
+					c.proto, f.Length)
+			} else if ti.BytesSent < uint64(f.Length) {
+				t.Errorf("%s: flow of length %d has only %d bytes sent",
+					c.proto, f.Length, ti.BytesSent)
+			}
+		}
+		sort.Ints(lengths)
+		if want := []int{0, 1, 100000}; !reflect.DeepEqual(lengths, want) {
+			t.Errorf("%s: flow lengths %v, want %v", c.proto, lengths, want)
+		}
+		if n := AnalyzeTransport(&data).Flows; n != 3 {
+			t.Errorf("%s: transport stats for %d flows, want 3", c.proto, n)
+		}
+	}
+}
+
+func TestUploadReadError(t *testing.T) {
+	c, err := net.Dial("tcp", startServer(t).Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// the chunk length "zz" is invalid, so reading the body fails
+	if _, err = io.WriteString(c, "POST "+FCTPath+" HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		FlowLengthHeader+": 10\r\n"+
+		"Transfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nabcde\r\nzz\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %s, want %d", resp.Status, http.StatusBadRequest)
+	}
+}
+
+func TestServerSockOpts(t *testing.T) {
+	o := SockOpts{
+		TOS:           0x20,
+		MaxPacingRate: 100 * bitrate.Mbps,
+		NotSentLowat:  16384,
+	}
+	s := startServer(t)
+	for _, c := range []struct {
+		proto string
+		addr  string
+	}{
+		{ProtocolHTTP, s.Listener.Addr().String()},
+		{ProtocolTCP, s.RawListener.Addr().String()},
+	} {
+		tst, err := NewTest(Params{
+			Addr:           c.addr,
+			Protocol:       c.proto,
+			ServerSockOpts: o,
+			ReplayTrace:    writeTrace(t, "0,1000\n0.01,1000\n"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tst.Run(context.Background()); err != nil {
+			t.Errorf("%s: %s", c.proto, err)
+		}
+	}
+	_, err := NewTest(Params{ServerSockOpts: SockOpts{TOS: 0x100}})
+	if err == nil {
+		t.Error("NewTest accepted an out of range server TOS")
+	}
+}
+
+func TestServerShutdownWaitsForRaw(t *testing.T) {
+	s := startServer(t)
+	c, err := net.Dial("tcp", s.RawListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitRawConns(t, s, 1)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err = <-done:
+		t.Fatalf("Shutdown returned with an active raw conn: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	h, err := newRawHeader(1, 1000, SockOpts{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = binary.Write(c, binary.BigEndian, &h); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.Copy(io.Discard, c); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("Shutdown: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown didn't return after the raw conn completed")
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	s := startServer(t)
+	c, err := net.Dial("tcp", s.RawListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	waitRawConns(t, s, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+	if err = s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown returned %v, want %v", err,
+			context.DeadlineExceeded)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("raw conn read returned %v, want %v", err, io.EOF)
+	}
+}
+
+func TestServerFlows(t *testing.T) {
+	var log bytes.Buffer
+	s := runServer(t, &Server{FlowLog: &log})
+	const length = 100000
+	var data Data
+	for _, c := range []struct {
+		proto string
+		dir   string
+	}{
+		{ProtocolHTTP, Download},
+		{ProtocolHTTP, Upload},
+		{ProtocolTCP, Download},
+		{ProtocolTCP, Upload},
+	} {
+		tst := &Test{Params: Params{Protocol: c.proto, Direction: c.dir}}
+		if c.proto == ProtocolTCP {
+			tst.Addr = s.RawListener.Addr().String()
+		} else {
+			tst.Addr = s.Listener.Addr().String()
+			tst.URL = "http://" + tst.Addr + FCTPath
+		}
+		f, err := tst.doFlow(context.Background(), length, "")
+		if err != nil {
+			t.Fatalf("%s %s: %s", c.proto, c.dir, err)
+		}
+		if f.Length != length {
+			t.Errorf("%s %s: flow length %d, want %d", c.proto, c.dir,
+				f.Length, length)
+		}
+		if f.Server == nil {
+			t.Errorf("%s %s: no server flow record", c.proto, c.dir)
+			continue
+		}
+		if f.Server.ID != f.ID {
+			t.Errorf("%s %s: server flow ID %d, want %d", c.proto, c.dir,
+				f.Server.ID, f.ID)
+		}
+		if f.Server.Bytes != length {
+			t.Errorf("%s %s: server flow bytes %d, want %d", c.proto, c.dir,
+				f.Server.Bytes, length)
+		}
+		f.Server = nil
+		data.AddFlow(f)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sf, err := ReadServerFlows(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := data.JoinServerFlows(sf); n != len(data.Flow) {
+		t.Errorf("joined %d flows from the server's log, want %d", n,
+			len(data.Flow))
+	}
+}
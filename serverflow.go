@@ -0,0 +1,131 @@
+package ccafct
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ServerFlow is the server's record of one flow. It's returned to the client
+// in the response trailer, and optionally logged by the server as NDJSON.
+type ServerFlow struct {
+	// ID is the flow ID sent by the client, or zero if none was sent.
+	ID uint64
+
+	// Accept is when the server accepted the flow's connection.
+	Accept time.Time
+
+	// Request is when the server read the request header.
+	Request time.Time
+
+	// FirstByte is when the first payload byte was handed to the kernel for
+	// downloads, or read for uploads. For HTTP downloads, it's when the
+	// first write to the buffered ResponseWriter returned.
+	FirstByte time.Time
+
+	// LastByte is when the last payload byte was handed to the kernel for
+	// downloads, or read for uploads. For HTTP downloads, it's when the
+	// ResponseWriter was flushed after the last write.
+	LastByte time.Time
+
+	// Bytes is the number of payload bytes written or read.
+	Bytes int64
+
+	// Error is the error that ended the flow early, if any. Flows with an
+	// error are only seen in the server's log, as the trailer isn't sent.
+	Error string `json:",omitempty"`
+
+	// CPU is the server's CPU time for the flow, if the server's FlowCPU is
+	// set. It includes system time spent sending and receiving in the
+	// server's thread, but not all network stack processing.
+	CPU time.Duration
+
+	// TCPInfo is the server's TCP_INFO for the flow, taken after the payload
+	// was written to the socket, or nil if it was unavailable. For downloads,
+	// it doesn't cover data still in the socket's send buffer.
+	TCPInfo *TCPInfo `json:",omitempty"`
+}
+
+// ServerStall returns the time from when the request was read until the first
+// payload byte, which is server processing time not due to the network.
+func (s ServerFlow) ServerStall() time.Duration {
+	if s.FirstByte.IsZero() {
+		return 0
+	}
+	return s.FirstByte.Sub(s.Request)
+}
+
+// recordWriter discards payload bytes, recording their count and the times
+// of the first and last writes in a ServerFlow.
+type recordWriter struct {
+	f *ServerFlow
+}
+
+func (w recordWriter) Write(p []byte) (n int, err error) {
+	w.f.record(len(p))
+	return len(p), nil
+}
+
+// record records n payload bytes written or read.
+func (s *ServerFlow) record(n int) {
+	if n <= 0 {
+		return
+	}
+	now := time.Now()
+	if s.FirstByte.IsZero() {
+		s.FirstByte = now
+	}
+	s.LastByte = now
+	s.Bytes += int64(n)
+}
+
+// flowLog writes ServerFlow records as NDJSON.
+type flowLog struct {
+	enc *json.Encoder
+	sync.Mutex
+}
+
+// log writes a ServerFlow record, if the log is enabled.
+func (l *flowLog) log(f *ServerFlow) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if err := l.enc.Encode(f); err != nil {
+		log.Printf("unable to write flow log: '%s'", err)
+	}
+}
+
+// ReadServerFlows reads ServerFlow records in NDJSON form.
+func ReadServerFlows(r io.Reader) (flows []ServerFlow, err error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var f ServerFlow
+		if err = dec.Decode(&f); err == io.EOF {
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+		flows = append(flows, f)
+	}
+}
+
+// ReadServerFlowsFile reads ServerFlow records from the named NDJSON file.
+func ReadServerFlowsFile(name string) (flows []ServerFlow, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+	if flows, err = ReadServerFlows(f); err != nil {
+		err = fmt.Errorf("%s: %s", name, err)
+	}
+	return
+}
@@ -0,0 +1,194 @@
+package ccafct
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/heistp/fct/bitrate"
+	"golang.org/x/sys/unix"
+)
+
+// SockOpts contains socket options for a TCP socket. Zero values are not set.
+type SockOpts struct {
+	// CCA is the congestion control algorithm (TCP_CONGESTION).
+	CCA string `json:",omitempty"`
+
+	// TOS is the IP TOS byte (IP_TOS, or IPV6_TCLASS for IPv6), i.e. the DSCP
+	// shifted left by two. Linux TCP manages the ECN bits itself, according to
+	// the CCA and the tcp_ecn sysctl, so only the DSCP bits take effect.
+	TOS int `json:",omitempty"`
+
+	// MaxPacingRate is the maximum pacing rate (SO_MAX_PACING_RATE).
+	MaxPacingRate bitrate.Bitrate `json:",omitempty"`
+
+	// SndBuf is the send buffer size (SO_SNDBUF).
+	SndBuf int `json:",omitempty"`
+
+	// RcvBuf is the receive buffer size (SO_RCVBUF).
+	RcvBuf int `json:",omitempty"`
+
+	// NotSentLowat is the limit on unsent bytes in the send buffer
+	// (TCP_NOTSENT_LOWAT).
+	NotSentLowat int `json:",omitempty"`
+}
+
+// Validate returns an error if any of the options are out of range.
+func (o SockOpts) Validate() error {
+	if o.TOS < 0 || o.TOS > 0xff {
+		return fmt.Errorf("TOS out of range: %d", o.TOS)
+	}
+	if o.MaxPacingRate < 0 || o.SndBuf < 0 || o.RcvBuf < 0 ||
+		o.NotSentLowat < 0 {
+		return fmt.Errorf("negative socket option in %s", o)
+	}
+	return nil
+}
+
+// Set sets the options on a socket file descriptor.
+func (o SockOpts) Set(fd int) (err error) {
+	if o.CCA != "" {
+		if err = unix.SetsockoptString(fd, unix.IPPROTO_TCP,
+			unix.TCP_CONGESTION, o.CCA); err != nil {
+			err = fmt.Errorf("unable to set TCP_CONGESTION to '%s': %s",
+				o.CCA, err)
+			return
+		}
+	}
+	if o.TOS != 0 {
+		if err = setTOS(fd, o.TOS); err != nil {
+			err = fmt.Errorf("unable to set TOS to %#x: %s", o.TOS, err)
+			return
+		}
+	}
+	if o.MaxPacingRate != 0 {
+		if err = unix.SetsockoptUint64(fd, unix.SOL_SOCKET,
+			unix.SO_MAX_PACING_RATE,
+			uint64(o.MaxPacingRate/8)); err != nil {
+			err = fmt.Errorf("unable to set SO_MAX_PACING_RATE to %s: %s",
+				o.MaxPacingRate, err)
+			return
+		}
+	}
+	if o.SndBuf != 0 {
+		if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF,
+			o.SndBuf); err != nil {
+			err = fmt.Errorf("unable to set SO_SNDBUF to %d: %s", o.SndBuf,
+				err)
+			return
+		}
+	}
+	if o.RcvBuf != 0 {
+		if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF,
+			o.RcvBuf); err != nil {
+			err = fmt.Errorf("unable to set SO_RCVBUF to %d: %s", o.RcvBuf,
+				err)
+			return
+		}
+	}
+	if o.NotSentLowat != 0 {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_TCP,
+			unix.TCP_NOTSENT_LOWAT, o.NotSentLowat); err != nil {
+			err = fmt.Errorf("unable to set TCP_NOTSENT_LOWAT to %d: %s",
+				o.NotSentLowat, err)
+			return
+		}
+	}
+	return
+}
+
+// setTOS sets the TOS byte on a socket, using IPV6_TCLASS for IPv6 sockets.
+func setTOS(fd, tos int) (err error) {
+	var sa unix.Sockaddr
+	if sa, err = unix.Getsockname(fd); err != nil {
+		return
+	}
+	if _, ok := sa.(*unix.SockaddrInet6); ok {
+		return unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_TCLASS,
+			tos)
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TOS, tos)
+}
+
+// Control is a net.Dialer Control function that sets the options on the
+// dialed socket before it connects.
+func (o SockOpts) Control(network, address string,
+	c syscall.RawConn) (err error) {
+	if cerr := c.Control(func(fd uintptr) {
+		err = o.Set(int(fd))
+	}); cerr != nil {
+		err = cerr
+	}
+	return
+}
+
+// String returns the options that are set, in the form name=value.
+func (o SockOpts) String() string {
+	var s []string
+	if o.CCA != "" {
+		s = append(s, "cca="+o.CCA)
+	}
+	if o.TOS != 0 {
+		s = append(s, fmt.Sprintf("tos=%#02x", o.TOS))
+	}
+	if o.MaxPacingRate != 0 {
+		s = append(s, "maxrate="+o.MaxPacingRate.String())
+	}
+	if o.SndBuf != 0 {
+		s = append(s, fmt.Sprintf("sndbuf=%d", o.SndBuf))
+	}
+	if o.RcvBuf != 0 {
+		s = append(s, fmt.Sprintf("rcvbuf=%d", o.RcvBuf))
+	}
+	if o.NotSentLowat != 0 {
+		s = append(s, fmt.Sprintf("notsent_lowat=%d", o.NotSentLowat))
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, " ")
+}
+
+// setHeader adds the options to the request headers.
+func (o SockOpts) setHeader(h http.Header) {
+	if o.CCA != "" {
+		h.Set(CCAHeader, o.CCA)
+	}
+	setInt := func(key string, v int64) {
+		if v != 0 {
+			h.Set(key, strconv.FormatInt(v, 10))
+		}
+	}
+	setInt(TOSHeader, int64(o.TOS))
+	setInt(MaxPacingRateHeader, int64(o.MaxPacingRate))
+	setInt(SndBufHeader, int64(o.SndBuf))
+	setInt(RcvBufHeader, int64(o.RcvBuf))
+	setInt(NotSentLowatHeader, int64(o.NotSentLowat))
+}
+
+// sockOptsFromHeader returns the options from the request headers.
+func sockOptsFromHeader(h http.Header) (o SockOpts, err error) {
+	getInt := func(key string) (v int64) {
+		s := h.Get(key)
+		if s == "" || err != nil {
+			return
+		}
+		if v, err = strconv.ParseInt(s, 10, 64); err != nil {
+			err = fmt.Errorf("invalid %s: '%s'", key, s)
+		}
+		return
+	}
+	o.CCA = h.Get(CCAHeader)
+	o.TOS = int(getInt(TOSHeader))
+	o.MaxPacingRate = bitrate.Bitrate(getInt(MaxPacingRateHeader))
+	o.SndBuf = int(getInt(SndBufHeader))
+	o.RcvBuf = int(getInt(RcvBufHeader))
+	o.NotSentLowat = int(getInt(NotSentLowatHeader))
+	if err != nil {
+		return
+	}
+	err = o.Validate()
+	return
+}
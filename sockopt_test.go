@@ -0,0 +1,168 @@
+package ccafct
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/heistp/fct/bitrate"
+	"golang.org/x/sys/unix"
+)
+
+func TestSockOptsControl(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	o := SockOpts{CCA: "reno", SndBuf: 64 * 1024, RcvBuf: 128 * 1024}
+	d := net.Dialer{Control: o.Control}
+	c, err := d.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var cca string
+	var snd, rcv int
+	if err = connControl(c.(*net.TCPConn), func(fd int) (err error) {
+		if cca, err = unix.GetsockoptString(fd, unix.IPPROTO_TCP,
+			unix.TCP_CONGESTION); err != nil {
+			return
+		}
+		if snd, err = unix.GetsockoptInt(fd, unix.SOL_SOCKET,
+			unix.SO_SNDBUF); err != nil {
+			return
+		}
+		rcv, err = unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF)
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if cca = strings.TrimRight(cca, "\x00"); cca != o.CCA {
+		t.Errorf("TCP_CONGESTION '%s', want '%s'", cca, o.CCA)
+	}
+	// Linux doubles the requested buffer sizes for bookkeeping overhead
+	if snd < o.SndBuf {
+		t.Errorf("SO_SNDBUF %d, want at least %d", snd, o.SndBuf)
+	}
+	if rcv < o.RcvBuf {
+		t.Errorf("SO_RCVBUF %d, want at least %d", rcv, o.RcvBuf)
+	}
+
+	o = SockOpts{CCA: "nosuchcca"}
+	d.Control = o.Control
+	if _, err = d.Dial("tcp", l.Addr().String()); err == nil {
+		t.Error("dialed with an unknown CCA")
+	}
+}
+
+func TestDialerFlowCCA(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, c := range []struct {
+		client string
+		flow   string
+		want   string
+	}{
+		{"", "reno", "reno"},
+		{"cubic", "reno", "cubic"},
+	} {
+		tst, err := NewTest(Params{ClientSockOpts: SockOpts{CCA: c.client}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := tst.dialer(c.flow).Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cca string
+		if err = connControl(conn.(*net.TCPConn), func(fd int) (err error) {
+			cca, err = unix.GetsockoptString(fd, unix.IPPROTO_TCP,
+				unix.TCP_CONGESTION)
+			return
+		}); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if cca = strings.TrimRight(cca, "\x00"); cca != c.want {
+			t.Errorf("client CCA '%s' for flow CCA '%s', want '%s'", cca,
+				c.flow, c.want)
+		}
+	}
+}
+
+func TestSockOptsHeader(t *testing.T) {
+	o := SockOpts{
+		CCA:           "reno",
+		TOS:           0x20,
+		MaxPacingRate: 50 * bitrate.Mbps,
+		SndBuf:        1 << 20,
+		NotSentLowat:  16384,
+	}
+	h := make(http.Header)
+	o.setHeader(h)
+	if _, ok := h[http.CanonicalHeaderKey(RcvBufHeader)]; ok {
+		t.Errorf("zero RcvBuf set in header: %v", h)
+	}
+	r, err := sockOptsFromHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != o {
+		t.Errorf("options from header %s, want %s", r, o)
+	}
+	if s, want := r.String(), "cca=reno tos=0x20 maxrate=50Mbps "+
+		"sndbuf=1048576 notsent_lowat=16384"; s != want {
+		t.Errorf("String() = '%s', want '%s'", s, want)
+	}
+
+	for _, v := range []string{"x", "256", "-1"} {
+		h = make(http.Header)
+		h.Set(TOSHeader, v)
+		if _, err = sockOptsFromHeader(h); err == nil {
+			t.Errorf("accepted TOS header '%s'", v)
+		}
+	}
+}
+
+func TestSockOptsSet(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	o := SockOpts{TOS: 0x20, NotSentLowat: 16384}
+	d := net.Dialer{Control: o.Control}
+	c, err := d.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var tos, lowat int
+	if err = connControl(c.(*net.TCPConn), func(fd int) (err error) {
+		if tos, err = unix.GetsockoptInt(fd, unix.IPPROTO_IP,
+			unix.IP_TOS); err != nil {
+			return
+		}
+		lowat, err = unix.GetsockoptInt(fd, unix.IPPROTO_TCP,
+			unix.TCP_NOTSENT_LOWAT)
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if tos != o.TOS {
+		t.Errorf("IP_TOS %#x, want %#x", tos, o.TOS)
+	}
+	if lowat != o.NotSentLowat {
+		t.Errorf("TCP_NOTSENT_LOWAT %d, want %d", lowat, o.NotSentLowat)
+	}
+}
@@ -3,8 +3,12 @@ package ccafct
 import (
 	"fmt"
 	"io"
+	"math"
 	"sort"
+	"strconv"
+	"time"
 
+	"github.com/heistp/fct/bitrate"
 	"github.com/heistp/fct/metric"
 	"github.com/heistp/fct/pretty"
 	"gonum.org/v1/gonum/stat"
@@ -12,20 +16,26 @@ import (
 
 // Stats contains the test statistics.
 type Stats struct {
-	// GeoMean is the geometric mean value.
-	GeoMean metric.FCT
+	// Set is the set of statistics computed.
+	Set StatSet
 
-	// Median is the median value.
-	Median metric.FCT
+	// Value contains the value of each statistic in Set. The value for
+	// StatCount is zero, and is given by Count instead.
+	Value []metric.FCT
 
-	// P95 is the 95th percentile value.
-	P95 metric.FCT
+	// Count is the number of values.
+	Count int
 }
 
-// Analyze analyzes the data to produce stats.
-func Analyze(d Data) (stats Stats, err error) {
+// Analyze analyzes the data to produce the statistics in set.
+func Analyze(d *Data, set StatSet) (stats Stats, err error) {
+	return AnalyzeDurations(d.FlowDurations(), set)
+}
+
+// AnalyzeDurations produces the statistics in set for a slice of durations.
+func AnalyzeDurations(durs []time.Duration, set StatSet) (stats Stats,
+	err error) {
 	// durations to floats
-	durs := d.FlowDurations()
 	if len(durs) == 0 {
 		err = fmt.Errorf("unable to analyze empty flow durations")
 		return
@@ -37,31 +47,506 @@ func Analyze(d Data) (stats Stats, err error) {
 	}
 	sort.Float64s(f)
 
-	geomean := stat.GeometricMean(f, nil)
-	median := stat.Quantile(0.5, stat.Empirical, f, nil)
-	p95 := stat.Quantile(0.95, stat.Empirical, f, nil)
-
 	stats = Stats{
-		GeoMean: metric.FCTFromFloat64(geomean),
-		Median:  metric.FCTFromFloat64(median),
-		P95:     metric.FCTFromFloat64(p95),
+		Set:   set,
+		Value: make([]metric.FCT, len(set)),
+		Count: len(f),
+	}
+	for i, st := range set {
+		var v float64
+		switch st {
+		case StatGeoMean:
+			v = stat.GeometricMean(f, nil)
+		case StatMean:
+			v = stat.Mean(f, nil)
+		case StatStdDev:
+			if len(f) > 1 {
+				v = stat.StdDev(f, nil)
+			}
+		case StatMin:
+			v = f[0]
+		case StatMax:
+			v = f[len(f)-1]
+		case StatCount:
+		default:
+			q, ok := st.Quantile()
+			if !ok {
+				err = st.Validate()
+				return
+			}
+			v = stat.Quantile(q, stat.Empirical, f, nil)
+		}
+		stats.Value[i] = metric.FCTFromFloat64(v)
 	}
 	return
 }
 
-// SetHarm sets harm stats relative to solo performance.
+// Get returns the value of a statistic, and true if it was computed.
+func (s Stats) Get(st Statistic) (v metric.FCT, ok bool) {
+	for i, t := range s.Set {
+		if t == st {
+			return s.Value[i], true
+		}
+	}
+	return
+}
+
+// SetHarm sets harm stats relative to solo performance, for each statistic
+// that was also computed for solo. Harm is skipped for zero workload values,
+// e.g. the stddev of a single flow, which would otherwise be Infinity.
 func (s *Stats) SetHarm(solo Stats) {
-	s.GeoMean.SetHarm(solo.GeoMean)
-	s.Median.SetHarm(solo.Median)
-	s.P95.SetHarm(solo.P95)
+	for i, st := range s.Set {
+		if !st.Harm() || s.Value[i].Duration == 0 {
+			continue
+		}
+		if v, ok := solo.Get(st); ok {
+			s.Value[i].SetHarm(v)
+		}
+	}
+}
+
+// Row returns the stats as table columns, one for each statistic in Set.
+func (s Stats) Row() (row []interface{}) {
+	for i, st := range s.Set {
+		if st == StatCount {
+			row = append(row, strconv.Itoa(s.Count))
+			continue
+		}
+		row = append(row, s.Value[i])
+	}
+	return
 }
 
 // Emit print the stats in text form.
 func (s *Stats) Emit(w io.Writer) {
 	tw := pretty.NewTableWriter(w)
 	tw.Printf("")
-	tw.Printf("GeoMean:\t%s", s.GeoMean)
-	tw.Printf("Median:\t%s", s.Median)
-	tw.Printf("P95:\t%s", s.P95)
+	for i, c := range s.Row() {
+		tw.Printf("%s:\t%s", s.Set[i].Label(), c)
+	}
+	tw.Flush()
+}
+
+// CCAStats contains the stats for the flows of each CCA in a workload.
+type CCAStats map[string]Stats
+
+// AnalyzeCCA analyzes the data to produce the statistics in set for each flow
+// CCA.
+func AnalyzeCCA(d *Data, set StatSet) (stats CCAStats, err error) {
+	stats = make(CCAStats)
+	for _, c := range d.CCAs() {
+		var s Stats
+		if s, err = AnalyzeDurations(d.CCADurations(c), set); err != nil {
+			return
+		}
+		stats[c] = s
+	}
+	return
+}
+
+// SetHarm sets harm stats relative to solo performance for each CCA. CCAs
+// without solo stats are left unchanged.
+func (s CCAStats) SetHarm(solo CCAStats) {
+	for c, st := range s {
+		if so, ok := solo[c]; ok {
+			st.SetHarm(so)
+			s[c] = st
+		}
+	}
+}
+
+// Emit prints the stats for each CCA in text form.
+func (s CCAStats) Emit(w io.Writer) {
+	ccas := make([]string, 0, len(s))
+	for c := range s {
+		ccas = append(ccas, c)
+	}
+	sort.Strings(ccas)
+	tw := pretty.NewTableWriter(w)
+	tw.Printf("")
+	for i, c := range ccas {
+		st := s[c]
+		if i == 0 {
+			h := []interface{}{""}
+			for _, l := range st.Set.Header("") {
+				h = append(h, l)
+			}
+			tw.Row(h...)
+		}
+		tw.Row(append([]interface{}{c + ":"}, st.Row()...)...)
+	}
+	tw.Flush()
+}
+
+// TimingStats contains stats for the phases of each flow.
+type TimingStats struct {
+	// Handshake contains the TCP handshake time stats.
+	Handshake Stats
+
+	// TTFB contains the stats for the time from handshake completion to the
+	// first response byte.
+	TTFB Stats
+
+	// Transfer contains the stats for the time from the first to the last
+	// response byte.
+	Transfer Stats
+}
+
+// AnalyzeTiming analyzes the data to produce the statistics in set for each
+// flow phase.
+func AnalyzeTiming(d *Data, set StatSet) (stats TimingStats, err error) {
+	if stats.Handshake, err = AnalyzeDurations(
+		d.TimedDurations(Flow.Handshake), set); err != nil {
+		return
+	}
+	if stats.TTFB, err = AnalyzeDurations(
+		d.TimedDurations(Flow.TTFB), set); err != nil {
+		return
+	}
+	stats.Transfer, err = AnalyzeDurations(d.TimedDurations(Flow.Transfer),
+		set)
+	return
+}
+
+// SetHarm sets harm stats relative to solo performance.
+func (s *TimingStats) SetHarm(solo TimingStats) {
+	s.Handshake.SetHarm(solo.Handshake)
+	s.TTFB.SetHarm(solo.TTFB)
+	s.Transfer.SetHarm(solo.Transfer)
+}
+
+// Emit prints the timing stats in text form.
+func (s *TimingStats) Emit(w io.Writer) {
+	tw := pretty.NewTableWriter(w)
+	tw.Printf("")
+	tw.Printf("\tHandshake\tTTFB\tTransfer")
+	h, t, x := s.Handshake.Row(), s.TTFB.Row(), s.Transfer.Row()
+	for i, st := range s.Handshake.Set {
+		tw.Printf("%s:\t%s\t%s\t%s", st.Label(), h[i], t[i], x[i])
+	}
+	tw.Flush()
+}
+
+// TransportStats summarizes the sender's TCP_INFO for the flows that have it,
+// which is the server's for downloads and the client's for uploads.
+type TransportStats struct {
+	// Flows is the number of flows with TCP_INFO.
+	Flows int
+
+	// RetransFlows is the fraction of flows with at least one retransmit.
+	RetransFlows float64
+
+	// Retransmits is the mean number of retransmits per flow.
+	Retransmits float64
+
+	// RetransBytes is the fraction of bytes sent that were retransmits, for
+	// kernels that report it.
+	RetransBytes float64
+
+	// CE is the fraction of delivered segments that were CE marked.
+	CE float64
+
+	// RTT is the median smoothed RTT.
+	RTT metric.Duration
+
+	// MinRTT is the median minimum RTT.
+	MinRTT metric.Duration
+
+	// CPU is the mean server CPU time per flow, for the flows that have it.
+	CPU metric.Duration
+}
+
+// AnalyzeTransport analyzes the TCP_INFO from the flows' senders to produce
+// transport stats.
+func AnalyzeTransport(d *Data) (stats TransportStats) {
+	var rtt, minRTT []float64
+	var retransFlows, retrans, sent, retransBytes, delivered, ce float64
+	var cpu time.Duration
+	var cpuFlows int
+	for _, f := range d.Flow {
+		if f.Server != nil && f.Server.CPU > 0 {
+			cpu += f.Server.CPU
+			cpuFlows++
+		}
+		ti := f.SenderTCPInfo()
+		if ti == nil {
+			continue
+		}
+		stats.Flows++
+		if ti.Retransmits > 0 {
+			retransFlows++
+		}
+		retrans += float64(ti.Retransmits)
+		sent += float64(ti.BytesSent)
+		retransBytes += float64(ti.BytesRetrans)
+		delivered += float64(ti.Delivered)
+		ce += float64(ti.DeliveredCE)
+		rtt = append(rtt, float64(ti.RTT))
+		minRTT = append(minRTT, float64(ti.MinRTT))
+	}
+	if cpuFlows > 0 {
+		stats.CPU = metric.Duration(cpu / time.Duration(cpuFlows))
+	}
+	if stats.Flows == 0 {
+		return
+	}
+	n := float64(stats.Flows)
+	stats.RetransFlows = retransFlows / n
+	stats.Retransmits = retrans / n
+	if sent > 0 {
+		stats.RetransBytes = retransBytes / sent
+	}
+	if delivered > 0 {
+		stats.CE = ce / delivered
+	}
+	sort.Float64s(rtt)
+	sort.Float64s(minRTT)
+	stats.RTT = metric.Duration(stat.Quantile(0.5, stat.Empirical, rtt, nil))
+	stats.MinRTT = metric.Duration(stat.Quantile(0.5, stat.Empirical, minRTT,
+		nil))
+	return
+}
+
+// Row returns the transport stats as table columns.
+func (s TransportStats) Row() []interface{} {
+	cpu := "-"
+	if s.CPU > 0 {
+		cpu = s.CPU.FormatMillis(3, true)
+	}
+	if s.Flows == 0 {
+		return []interface{}{"-", "-", "-", "-", "-", "-", cpu}
+	}
+	return []interface{}{
+		pretty.Float64(s.Retransmits, 2),
+		pretty.Float64(s.RetransFlows*100, 1) + "%",
+		pretty.Float64(s.RetransBytes*100, 2) + "%",
+		pretty.Float64(s.CE*100, 2) + "%",
+		s.RTT,
+		s.MinRTT,
+		cpu,
+	}
+}
+
+// TransportHeader contains the column headers for TransportStats.Row.
+var TransportHeader = []string{"Retrans/Flow", "Flows w/ Retrans",
+	"Retrans Bytes", "CE Marked", "Median SRTT", "Median MinRTT",
+	"Server CPU/Flow"}
+
+// Emit prints the transport stats in text form.
+func (s TransportStats) Emit(w io.Writer) {
+	tw := pretty.NewTableWriter(w)
+	tw.Printf("")
+	for i, c := range s.Row() {
+		tw.Printf("%s:\t%s", TransportHeader[i], c)
+	}
+	tw.Flush()
+}
+
+// BulkStats summarizes the samples from bulk flows.
+type BulkStats struct {
+	// Flows is the number of flows with samples.
+	Flows int
+
+	// Throughput is the total mean throughput of the flows.
+	Throughput metric.Throughput
+
+	// Retransmits is the total number of retransmits for the flows.
+	Retransmits int
+
+	// RTT is the median of the sender's smoothed RTT samples.
+	RTT metric.Duration
+}
+
+// BulkSamplesBetween returns the samples for intervals that ended after start,
+// and no later than end.
+func BulkSamplesBetween(samples []BulkSample, start, end time.Time) (
+	between []BulkSample) {
+	for _, s := range samples {
+		if s.Time.After(start) && !s.Time.After(end) {
+			between = append(between, s)
+		}
+	}
+	return
+}
+
+// BulkSamplesInDirection returns the samples for flows in the given direction,
+// either Download or Upload. Samples without a direction are downloads.
+func BulkSamplesInDirection(samples []BulkSample, dir string) (
+	in []BulkSample) {
+	for _, s := range samples {
+		d := s.Direction
+		if d == "" {
+			d = Download
+		}
+		if d == dir {
+			in = append(in, s)
+		}
+	}
+	return
+}
+
+// AnalyzeBulk analyzes samples from bulk flows to produce bulk stats.
+func AnalyzeBulk(samples []BulkSample) (stats BulkStats) {
+	if len(samples) == 0 {
+		return
+	}
+	retrans := make(map[int]uint32)
+	var rtt []float64
+	var bytes int64
+	start, end := samples[0].Time, samples[0].Time
+	for _, s := range samples {
+		if _, ok := retrans[s.Flow]; !ok {
+			retrans[s.Flow] = 0
+		}
+		bytes += s.Bytes
+		if t := s.Time.Add(-s.Interval); t.Before(start) {
+			start = t
+		}
+		if s.Time.After(end) {
+			end = s.Time
+		}
+		if s.TCPInfo == nil {
+			continue
+		}
+		if s.TCPInfo.Retransmits > retrans[s.Flow] {
+			retrans[s.Flow] = s.TCPInfo.Retransmits
+		}
+		rtt = append(rtt, float64(s.TCPInfo.RTT))
+	}
+	stats.Flows = len(retrans)
+	for _, r := range retrans {
+		stats.Retransmits += int(r)
+	}
+	if d := end.Sub(start); d > 0 {
+		stats.Throughput.Bitrate = bitrate.Bitrate(float64(bytes) * 8 /
+			d.Seconds())
+	}
+	if len(rtt) > 0 {
+		sort.Float64s(rtt)
+		stats.RTT = metric.Duration(stat.Quantile(0.5, stat.Empirical, rtt,
+			nil))
+	}
+	return
+}
+
+// SetHarm sets the throughput harm relative to the stats for the flows
+// alone, with throughput as a "more is better" metric.
+func (s *BulkStats) SetHarm(solo BulkStats) {
+	s.Throughput.SetHarm(solo.Throughput)
+}
+
+// Row returns the bulk stats as table columns.
+func (s BulkStats) Row() []interface{} {
+	if s.Flows == 0 {
+		return []interface{}{"-", "-", "-", "-"}
+	}
+	return []interface{}{strconv.Itoa(s.Flows), s.Throughput,
+		strconv.Itoa(s.Retransmits), s.RTT}
+}
+
+// BulkHeader contains the column headers for BulkStats.Row.
+var BulkHeader = []string{"Flows", "Throughput", "Retransmits",
+	"Median SRTT"}
+
+// Emit prints the bulk stats in text form.
+func (s BulkStats) Emit(w io.Writer) {
+	tw := pretty.NewTableWriter(w)
+	tw.Printf("")
+	for i, c := range s.Row() {
+		tw.Printf("%s:\t%s", BulkHeader[i], c)
+	}
+	tw.Flush()
+}
+
+// ProbeStats summarizes the samples from the latency probe.
+type ProbeStats struct {
+	// Sent is the number of probes sent.
+	Sent int
+
+	// Loss is the fraction of probes that were lost.
+	Loss float64
+
+	// Median is the median RTT.
+	Median metric.RTT
+
+	// P95 is the 95th percentile RTT.
+	P95 metric.RTT
+
+	// P99 is the 99th percentile RTT.
+	P99 metric.RTT
+
+	// Jitter is the mean absolute difference between the RTTs of
+	// consecutive probes that weren't lost.
+	Jitter metric.Duration
+}
+
+// AnalyzeProbe analyzes samples from the latency probe to produce probe
+// stats.
+func AnalyzeProbe(samples []ProbeSample) (stats ProbeStats) {
+	stats.Sent = len(samples)
+	if stats.Sent == 0 {
+		return
+	}
+	var rtt []float64
+	var lost int
+	var jitter float64
+	for _, s := range samples {
+		if s.Lost() {
+			lost++
+			continue
+		}
+		r := float64(s.RTT)
+		if len(rtt) > 0 {
+			jitter += math.Abs(r - rtt[len(rtt)-1])
+		}
+		rtt = append(rtt, r)
+	}
+	stats.Loss = float64(lost) / float64(stats.Sent)
+	if len(rtt) == 0 {
+		return
+	}
+	if len(rtt) > 1 {
+		stats.Jitter = metric.Duration(jitter / float64(len(rtt)-1))
+	}
+	sort.Float64s(rtt)
+	q := func(p float64) metric.RTT {
+		return metric.RTT{
+			Duration: metric.Duration(stat.Quantile(p, stat.Empirical, rtt,
+				nil)),
+		}
+	}
+	stats.Median = q(0.5)
+	stats.P95 = q(0.95)
+	stats.P99 = q(0.99)
+	return
+}
+
+// SetHarm sets the RTT harm relative to the probe stats without competition.
+func (s *ProbeStats) SetHarm(solo ProbeStats) {
+	s.Median.SetHarm(solo.Median)
+	s.P95.SetHarm(solo.P95)
+	s.P99.SetHarm(solo.P99)
+}
+
+// Row returns the probe stats as table columns.
+func (s ProbeStats) Row() []interface{} {
+	if s.Sent == 0 {
+		return []interface{}{"-", "-", "-", "-", "-"}
+	}
+	return []interface{}{s.Median, s.P95, s.P99, s.Jitter,
+		pretty.Float64(s.Loss*100, 2) + "%"}
+}
+
+// ProbeHeader contains the column headers for ProbeStats.Row.
+var ProbeHeader = []string{"Median RTT (Harm)", "P95 RTT (Harm)",
+	"P99 RTT (Harm)", "Jitter", "Loss"}
+
+// Emit prints the probe stats in text form.
+func (s ProbeStats) Emit(w io.Writer) {
+	tw := pretty.NewTableWriter(w)
+	tw.Printf("")
+	for i, c := range s.Row() {
+		tw.Printf("%s:\t%s", ProbeHeader[i], c)
+	}
 	tw.Flush()
 }
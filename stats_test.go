@@ -0,0 +1,90 @@
+package ccafct
+
+import (
+	"testing"
+	"time"
+
+	"github.com/heistp/fct/bitrate"
+)
+
+// bulkSamples returns one second samples for two flows, starting at t0, with
+// each flow receiving bytes per second.
+func bulkSamples(t0 time.Time, seconds int, bytes int64) (s []BulkSample) {
+	for i := 1; i <= seconds; i++ {
+		for f := 0; f < 2; f++ {
+			s = append(s, BulkSample{
+				Flow:     f,
+				Time:     t0.Add(time.Duration(i) * time.Second),
+				Interval: time.Second,
+				Bytes:    bytes,
+				TCPInfo: &TCPInfo{
+					Retransmits: uint32(i),
+					RTT:         time.Duration(i) * time.Millisecond,
+				},
+			})
+		}
+	}
+	return
+}
+
+func TestAnalyzeBulk(t *testing.T) {
+	t0 := time.Now()
+	s := AnalyzeBulk(bulkSamples(t0, 4, 1250000))
+	if s.Flows != 2 {
+		t.Errorf("flows %d, want 2", s.Flows)
+	}
+	if b := s.Throughput.Bitrate; b != 20*bitrate.Mbps {
+		t.Errorf("throughput %s, want 20Mbps", b)
+	}
+	if s.Retransmits != 8 {
+		t.Errorf("retransmits %d, want 8", s.Retransmits)
+	}
+	if r := time.Duration(s.RTT); r != 2*time.Millisecond {
+		t.Errorf("RTT %s, want 2ms", r)
+	}
+}
+
+func TestBulkThroughputHarm(t *testing.T) {
+	t0 := time.Now()
+	samples := append(bulkSamples(t0, 2, 1250000),
+		bulkSamples(t0.Add(2*time.Second), 2, 625000)...)
+	solo := AnalyzeBulk(BulkSamplesBetween(samples, t0,
+		t0.Add(2*time.Second)))
+	work := AnalyzeBulk(BulkSamplesBetween(samples, t0.Add(2*time.Second),
+		t0.Add(4*time.Second)))
+	if b := solo.Throughput.Bitrate; b != 20*bitrate.Mbps {
+		t.Errorf("solo throughput %s, want 20Mbps", b)
+	}
+	if b := work.Throughput.Bitrate; b != 10*bitrate.Mbps {
+		t.Errorf("workload throughput %s, want 10Mbps", b)
+	}
+	work.SetHarm(solo)
+	if h := work.Throughput.Harm; h != 0.5 {
+		t.Errorf("throughput harm %s, want 0.500", h)
+	}
+	solo.SetHarm(work)
+	if h := solo.Throughput.Harm; !h.Zero() {
+		t.Errorf("harm %s for a throughput increase, want zero", h)
+	}
+}
+
+func TestStatsHarmSingleFlow(t *testing.T) {
+	set := StatSet{StatMean, StatStdDev}
+	solo, err := AnalyzeDurations([]time.Duration{10 * time.Millisecond,
+		30 * time.Millisecond}, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a single flow has a stddev of zero, which is not harmful
+	work, err := AnalyzeDurations([]time.Duration{40 * time.Millisecond}, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work.SetHarm(solo)
+	if h := work.Value[0].Harm; h != 0.5 {
+		t.Errorf("mean harm %s, want 0.500", h)
+	}
+	if h := work.Value[1].Harm; !h.Zero() {
+		t.Errorf("stddev harm %s for a single flow, want zero", h)
+	}
+}
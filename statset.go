@@ -0,0 +1,179 @@
+package ccafct
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Statistics, other than quantiles. Quantiles are named median, or p followed
+// by a percentile, e.g. p99 or p99.9.
+const (
+	// StatGeoMean is the geometric mean.
+	StatGeoMean Statistic = "geomean"
+
+	// StatMean is the arithmetic mean.
+	StatMean Statistic = "mean"
+
+	// StatStdDev is the sample standard deviation.
+	StatStdDev Statistic = "stddev"
+
+	// StatMin is the minimum.
+	StatMin Statistic = "min"
+
+	// StatMax is the maximum.
+	StatMax Statistic = "max"
+
+	// StatCount is the number of values.
+	StatCount Statistic = "count"
+
+	// StatMedian is the median, the same as p50.
+	StatMedian Statistic = "median"
+)
+
+// Statistic is the name of a statistic computed over flow durations.
+type Statistic string
+
+// parseStatistic returns the statistic named s, in any case. Quantiles are
+// normalized to a canonical form, e.g. P99.0 to p99, and p50 to median.
+func parseStatistic(s string) Statistic {
+	st := Statistic(strings.ToLower(strings.TrimSpace(s)))
+	if p, ok := st.percentile(); ok {
+		if p == 50 {
+			return StatMedian
+		}
+		st = Statistic("p" + strconv.FormatFloat(p, 'f', -1, 64))
+	}
+	return st
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, so statistics may be
+// given in any case, e.g. "P99".
+func (s *Statistic) UnmarshalText(b []byte) error {
+	*s = parseStatistic(string(b))
+	return nil
+}
+
+// Quantile returns the quantile from 0 to 1, and true, if the statistic is a
+// quantile.
+func (s Statistic) Quantile() (q float64, ok bool) {
+	if s == StatMedian {
+		return 0.5, true
+	}
+	var p float64
+	if p, ok = s.percentile(); ok {
+		q = p / 100
+	}
+	return
+}
+
+// percentile returns the percentile from 0 to 100, and true, if the statistic
+// is p followed by a percentile.
+func (s Statistic) percentile() (p float64, ok bool) {
+	if !strings.HasPrefix(string(s), "p") {
+		return
+	}
+	v, err := strconv.ParseFloat(string(s[1:]), 64)
+	if err != nil || math.IsNaN(v) || v < 0 || v > 100 {
+		return
+	}
+	// p-0 is p0
+	return math.Abs(v), true
+}
+
+// Validate returns an error if the statistic is unknown.
+func (s Statistic) Validate() error {
+	switch s {
+	case StatGeoMean, StatMean, StatStdDev, StatMin, StatMax, StatCount:
+		return nil
+	}
+	if _, ok := s.Quantile(); ok {
+		return nil
+	}
+	return fmt.Errorf("unknown statistic: '%s' (expected geomean, mean, "+
+		"stddev, min, max, count, median or a percentile like p99.9)", s)
+}
+
+// Harm returns true if harm is calculated for the statistic.
+func (s Statistic) Harm() bool {
+	return s != StatCount
+}
+
+// Label returns the statistic's column label.
+func (s Statistic) Label() string {
+	switch s {
+	case StatGeoMean:
+		return "GeoMean"
+	case StatMean:
+		return "Mean"
+	case StatStdDev:
+		return "StdDev"
+	case StatMin:
+		return "Min"
+	case StatMax:
+		return "Max"
+	case StatCount:
+		return "Count"
+	case StatMedian:
+		return "Median"
+	}
+	return strings.ToUpper(string(s))
+}
+
+// StatSet is a list of statistics to compute.
+type StatSet []Statistic
+
+// DefaultStatSet is the default set of statistics.
+var DefaultStatSet = StatSet{StatGeoMean, StatMedian, "p95"}
+
+// ParseStatSet parses a comma separated list of statistics, e.g.
+// "median,p99,p99.9,mean,stddev".
+func ParseStatSet(s string) (set StatSet, err error) {
+	for _, f := range strings.Split(s, ",") {
+		set = append(set, parseStatistic(f))
+	}
+	err = set.Validate()
+	return
+}
+
+// Validate returns an error if the set is empty, or contains an unknown or
+// duplicate statistic.
+func (s StatSet) Validate() error {
+	if len(s) == 0 {
+		return fmt.Errorf("no statistics given")
+	}
+	seen := make(map[Statistic]bool)
+	for _, st := range s {
+		if err := st.Validate(); err != nil {
+			return err
+		}
+		if seen[st] {
+			return fmt.Errorf("duplicate statistic: '%s'", st)
+		}
+		seen[st] = true
+	}
+	return nil
+}
+
+// Header returns the column labels for the statistics, with suffix added to
+// those that have harm, e.g. " (Harm)".
+func (s StatSet) Header(suffix string) (h []string) {
+	for _, st := range s {
+		l := st.Label()
+		if st.Harm() {
+			l += suffix
+		}
+		h = append(h, l)
+	}
+	return
+}
+
+// String returns the statistics as a comma separated list.
+func (s StatSet) String() string {
+	f := make([]string, len(s))
+	for i, st := range s {
+		f[i] = string(st)
+	}
+	return strings.Join(f, ",")
+}
@@ -0,0 +1,78 @@
+package ccafct
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStatSet(t *testing.T) {
+	for _, c := range []struct {
+		in  string
+		set StatSet
+		err string
+	}{
+		{"geomean,median,p95", StatSet{StatGeoMean, StatMedian, "p95"}, ""},
+		{" Median , P99.9,MEAN,stddev", StatSet{StatMedian, "p99.9",
+			StatMean, StatStdDev}, ""},
+		{"min,max,count,p0,p100", StatSet{StatMin, StatMax, StatCount, "p0",
+			"p100"}, ""},
+		{"p50", StatSet{StatMedian}, ""},
+		{"p50.0", StatSet{StatMedian}, ""},
+		{"p99.0,p099.90,p1e-1,p-0", StatSet{"p99", "p99.9", "p0.1", "p0"},
+			""},
+		{"", nil, "unknown statistic: ''"},
+		{"mode", nil, "unknown statistic: 'mode'"},
+		{"p", nil, "unknown statistic: 'p'"},
+		{"p101", nil, "unknown statistic: 'p101'"},
+		{"p-1", nil, "unknown statistic: 'p-1'"},
+		{"pnan", nil, "unknown statistic: 'pnan'"},
+		{"median,p99,median", nil, "duplicate statistic: 'median'"},
+		{"median,p50", nil, "duplicate statistic: 'median'"},
+		{"p99,P99.0", nil, "duplicate statistic: 'p99'"},
+		{"p99,p9.9e1", nil, "duplicate statistic: 'p99'"},
+		{"p99.9,p99.90", nil, "duplicate statistic: 'p99.9'"},
+	} {
+		set, err := ParseStatSet(c.in)
+		if c.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+				t.Errorf("ParseStatSet(%q) error '%v', want prefix '%s'", c.in,
+					err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseStatSet(%q): %s", c.in, err)
+		} else if !reflect.DeepEqual(set, c.set) {
+			t.Errorf("ParseStatSet(%q) = %v, want %v", c.in, set, c.set)
+		}
+	}
+}
+
+func TestStatSetUnmarshal(t *testing.T) {
+	var set StatSet
+	if err := json.Unmarshal([]byte(`["P50", "p99.0", "GeoMean"]`),
+		&set); err != nil {
+		t.Fatal(err)
+	}
+	want := StatSet{StatMedian, "p99", StatGeoMean}
+	if !reflect.DeepEqual(set, want) {
+		t.Errorf("unmarshaled %v, want %v", set, want)
+	}
+}
+
+func TestStatSetHeader(t *testing.T) {
+	set, err := ParseStatSet("P99.0,p99.90,p050,geomean,count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"P99 (Harm)", "P99.9 (Harm)", "Median (Harm)",
+		"GeoMean (Harm)", "Count"}
+	if h := set.Header(" (Harm)"); !reflect.DeepEqual(h, want) {
+		t.Errorf("header %q, want %q", h, want)
+	}
+	if s := set.String(); s != "p99,p99.9,median,geomean,count" {
+		t.Errorf("String() = '%s', want canonical names", s)
+	}
+}
@@ -0,0 +1,149 @@
+package ccafct
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/heistp/fct/bitrate"
+	"golang.org/x/sys/unix"
+)
+
+// TCPInfo contains selected fields from the Linux TCP_INFO socket option.
+// Fields not supported by the kernel are zero.
+type TCPInfo struct {
+	// Retransmits is the total number of retransmitted segments.
+	Retransmits uint32
+
+	// Lost is the number of segments currently considered lost. It's a
+	// snapshot, not a cumulative count.
+	Lost uint32
+
+	// BytesSent is the total number of data bytes sent, including
+	// retransmits.
+	BytesSent uint64 `json:",omitempty"`
+
+	// BytesRetrans is the total number of data bytes retransmitted.
+	BytesRetrans uint64 `json:",omitempty"`
+
+	// RTT is the smoothed RTT.
+	RTT time.Duration
+
+	// RTTVar is the RTT variance.
+	RTTVar time.Duration
+
+	// MinRTT is the minimum RTT seen.
+	MinRTT time.Duration
+
+	// Cwnd is the congestion window, in segments.
+	Cwnd uint32
+
+	// DeliveryRate is the most recent delivery rate.
+	DeliveryRate bitrate.Bitrate
+
+	// ECN is true if ECN was negotiated.
+	ECN bool
+
+	// ECNSeen is true if at least one ECT packet was received.
+	ECNSeen bool
+
+	// Delivered is the number of data segments delivered, including
+	// retransmits.
+	Delivered uint32
+
+	// DeliveredCE is the number of delivered data segments with CE marks.
+	DeliveredCE uint32
+}
+
+// TCP_INFO option flags, from linux/tcp.h.
+const (
+	tcpiOptECN     = 8
+	tcpiOptECNSeen = 16
+)
+
+// rawTCPInfo is the Linux struct tcp_info from linux/tcp.h. Older kernels
+// return a prefix of it.
+type rawTCPInfo struct {
+	State         uint8
+	CAState       uint8
+	Retransmits   uint8
+	Probes        uint8
+	Backoff       uint8
+	Options       uint8
+	WScale        uint8
+	AppLimited    uint8
+	RTO           uint32
+	ATO           uint32
+	SndMSS        uint32
+	RcvMSS        uint32
+	Unacked       uint32
+	Sacked        uint32
+	Lost          uint32
+	Retrans       uint32
+	Fackets       uint32
+	LastDataSent  uint32
+	LastAckSent   uint32
+	LastDataRecv  uint32
+	LastAckRecv   uint32
+	PMTU          uint32
+	RcvSsthresh   uint32
+	RTT           uint32
+	RTTVar        uint32
+	SndSsthresh   uint32
+	SndCwnd       uint32
+	AdvMSS        uint32
+	Reordering    uint32
+	RcvRTT        uint32
+	RcvSpace      uint32
+	TotalRetrans  uint32
+	PacingRate    uint64
+	MaxPacingRate uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+	SegsOut       uint32
+	SegsIn        uint32
+	NotsentBytes  uint32
+	MinRTT        uint32
+	DataSegsIn    uint32
+	DataSegsOut   uint32
+	DeliveryRate  uint64
+	BusyTime      uint64
+	RwndLimited   uint64
+	SndbufLimited uint64
+	Delivered     uint32
+	DeliveredCE   uint32
+	BytesSent     uint64
+	BytesRetrans  uint64
+	DsackDups     uint32
+	ReordSeen     uint32
+	RcvOOOPack    uint32
+	SndWnd        uint32
+}
+
+// GetTCPInfo returns the TCPInfo for a socket file descriptor.
+func GetTCPInfo(fd int) (info TCPInfo, err error) {
+	var raw rawTCPInfo
+	l := uint32(unsafe.Sizeof(raw))
+	if _, _, e := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd),
+		unix.IPPROTO_TCP, unix.TCP_INFO, uintptr(unsafe.Pointer(&raw)),
+		uintptr(unsafe.Pointer(&l)), 0); e != 0 {
+		err = fmt.Errorf("getsockopt TCP_INFO: %s", e)
+		return
+	}
+	info = TCPInfo{
+		Retransmits:  raw.TotalRetrans,
+		Lost:         raw.Lost,
+		BytesSent:    raw.BytesSent,
+		BytesRetrans: raw.BytesRetrans,
+		RTT:          time.Duration(raw.RTT) * time.Microsecond,
+		RTTVar:       time.Duration(raw.RTTVar) * time.Microsecond,
+		MinRTT:       time.Duration(raw.MinRTT) * time.Microsecond,
+		Cwnd:         raw.SndCwnd,
+		DeliveryRate: bitrate.Bitrate(raw.DeliveryRate * 8),
+		ECN:          raw.Options&tcpiOptECN != 0,
+		ECNSeen:      raw.Options&tcpiOptECNSeen != 0,
+		Delivered:    raw.Delivered,
+		DeliveredCE:  raw.DeliveredCE,
+	}
+	return
+}
@@ -0,0 +1,170 @@
+package ccafct
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/heistp/fct/unit"
+)
+
+// Arrival is one flow in a workload schedule.
+type Arrival struct {
+	// Offset is the flow's arrival time, relative to the start of the test.
+	Offset time.Duration
+
+	// Length is the requested flow length.
+	Length unit.Bytes
+
+	// CCA is the flow's congestion control algorithm, or empty to use the
+	// test's CCA.
+	CCA string
+}
+
+// ReadTrace reads a workload trace in CSV form. Each record contains a
+// timestamp in seconds, a flow length in bytes and an optional CCA.
+// Timestamps may be absolute (e.g. Unix times from an access log) or
+// relative, and the records need not be sorted, as the returned arrivals are
+// sorted and offset relative to the earliest timestamp. Blank lines, lines
+// starting with '#' and a header line are ignored. Errors give the record
+// number, not counting blank or comment lines.
+func ReadTrace(r io.Reader) (trace []Arrival, err error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	type record struct {
+		ts  float64
+		len unit.Bytes
+		cca string
+	}
+	var recs []record
+	for n := 1; ; n++ {
+		var f []string
+		if f, err = cr.Read(); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			return
+		}
+		if len(f) < 2 {
+			err = fmt.Errorf("trace record %d: expected timestamp,size", n)
+			return
+		}
+		var ts float64
+		var l int64
+		var terr, lerr error
+		ts, terr = strconv.ParseFloat(strings.TrimSpace(f[0]), 64)
+		l, lerr = strconv.ParseInt(strings.TrimSpace(f[1]), 10, 64)
+		if terr != nil || lerr != nil {
+			if len(recs) == 0 && n == 1 {
+				continue // header
+			}
+			err = fmt.Errorf("trace record %d: invalid record: '%s'", n,
+				strings.Join(f, ","))
+			return
+		}
+		if math.IsNaN(ts) || math.IsInf(ts, 0) {
+			err = fmt.Errorf("trace record %d: invalid timestamp: %f", n, ts)
+			return
+		}
+		if l < 0 {
+			err = fmt.Errorf("trace record %d: negative size: %d", n, l)
+			return
+		}
+		var cca string
+		if len(f) > 2 {
+			cca = strings.TrimSpace(f[2])
+		}
+		recs = append(recs, record{ts, unit.Bytes(l), cca})
+	}
+	if len(recs) == 0 {
+		err = fmt.Errorf("trace contains no records")
+		return
+	}
+
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].ts < recs[j].ts
+	})
+	trace = make([]Arrival, len(recs))
+	t0 := recs[0].ts
+	for i, r := range recs {
+		trace[i] = Arrival{
+			Offset: time.Duration((r.ts - t0) * float64(time.Second)),
+			Length: r.len,
+			CCA:    r.cca,
+		}
+	}
+
+	return
+}
+
+// WriteTrace writes a workload trace in the CSV form read by ReadTrace. The
+// CCA column is written only if at least one arrival has a CCA.
+func WriteTrace(w io.Writer, trace []Arrival) (err error) {
+	bw := bufio.NewWriter(w)
+	var cca bool
+	for _, a := range trace {
+		if a.CCA != "" {
+			cca = true
+			break
+		}
+	}
+	h := "timestamp,size"
+	if cca {
+		h += ",cca"
+	}
+	if _, err = fmt.Fprintln(bw, h); err != nil {
+		return
+	}
+	for _, a := range trace {
+		if cca {
+			_, err = fmt.Fprintf(bw, "%.9f,%d,%s\n", a.Offset.Seconds(),
+				a.Length, a.CCA)
+		} else {
+			_, err = fmt.Fprintf(bw, "%.9f,%d\n", a.Offset.Seconds(),
+				a.Length)
+		}
+		if err != nil {
+			return
+		}
+	}
+	err = bw.Flush()
+	return
+}
+
+// ReadTraceFile reads a workload trace from the named file.
+func ReadTraceFile(name string) (trace []Arrival, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+	if trace, err = ReadTrace(f); err != nil {
+		err = fmt.Errorf("%s: %s", name, err)
+	}
+	return
+}
+
+// WriteTraceFile writes a workload trace to the named file.
+func WriteTraceFile(name string, trace []Arrival) (err error) {
+	var f *os.File
+	if f, err = os.Create(name); err != nil {
+		return
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	err = WriteTrace(f, trace)
+	return
+}
@@ -0,0 +1,156 @@
+package ccafct
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceRoundTrip(t *testing.T) {
+	trace := []Arrival{
+		{0, 1000, "cubic"},
+		{250 * time.Microsecond, 0, ""},
+		{1500 * time.Millisecond, 65536, "bbr"},
+		{2 * time.Second, 2000000, ""},
+	}
+	var b bytes.Buffer
+	if err := WriteTrace(&b, trace); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := ReadTrace(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tr, trace) {
+		t.Errorf("read %v, want %v", tr, trace)
+	}
+}
+
+func TestReadTraceOffsetsAbsoluteTimes(t *testing.T) {
+	tr, err := ReadTrace(strings.NewReader(`time,bytes
+# from an access log, out of order
+1700000002.5,200
+
+1700000001,100
+1700000001.25, 300, reno
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Arrival{
+		{0, 100, ""},
+		{250 * time.Millisecond, 300, "reno"},
+		{1500 * time.Millisecond, 200, ""},
+	}
+	if !reflect.DeepEqual(tr, want) {
+		t.Errorf("read %v, want %v", tr, want)
+	}
+}
+
+func TestReadTraceRejects(t *testing.T) {
+	for _, c := range []struct {
+		csv    string
+		record int
+	}{
+		{"1,100\n2\n", 2},
+		{"# comment\n1,100\n\n2,x\n", 2},
+		{"1,100\n2,-1\n", 2},
+		{"NaN,100\n", 1},
+		{"1,100\n+Inf,100\n", 2},
+		{"1,100\n2,100\n-inf,100\n", 3},
+	} {
+		_, err := ReadTrace(strings.NewReader(c.csv))
+		if err == nil {
+			t.Errorf("ReadTrace(%q) succeeded", c.csv)
+		} else if r := fmt.Sprintf("record %d:", c.record); !strings.Contains(
+			err.Error(), r) {
+			t.Errorf("ReadTrace(%q) error '%s' doesn't give %s", c.csv, err,
+				r)
+		}
+	}
+	if _, err := ReadTrace(strings.NewReader("timestamp,size\n")); err == nil {
+		t.Error("ReadTrace of a header only succeeded")
+	}
+}
+
+// writeTrace writes a trace file to a temporary directory, and returns its
+// name.
+func writeTrace(t *testing.T, text string) string {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), "trace.csv")
+	if err := os.WriteFile(f, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestReplayTraceParams(t *testing.T) {
+	tst, err := NewTest(Params{
+		ReplayTrace: writeTrace(t, "10,1000\n11,3000\n12,2000\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tst.Flows != 3 {
+		t.Errorf("flows %d, want 3", tst.Flows)
+	}
+	if tst.Duration != 2*time.Second {
+		t.Errorf("duration %s, want 2s", tst.Duration)
+	}
+	if tst.MeanArrival != time.Second {
+		t.Errorf("mean arrival %s, want 1s", tst.MeanArrival)
+	}
+	if tst.MeanFlowLen != 2000 {
+		t.Errorf("mean flow length %d, want 2000", tst.MeanFlowLen)
+	}
+	if tst.Bandwidth != 24000 {
+		t.Errorf("bandwidth %d, want 24000", tst.Bandwidth)
+	}
+}
+
+func TestReplayTraceSpansNoTime(t *testing.T) {
+	for _, tr := range []string{"5,1000\n", "5,1000\n5,2000\n"} {
+		if _, err := NewTest(Params{
+			ReplayTrace: writeTrace(t, tr),
+		}); err == nil {
+			t.Errorf("NewTest accepted trace %q, which spans no time", tr)
+		}
+	}
+}
+
+func TestReplayTraceRecorded(t *testing.T) {
+	r := newLengthRecorder(t)
+	rec := filepath.Join(t.TempDir(), "recorded.csv")
+	tst, err := NewTest(Params{
+		Addr:        r.addr(),
+		Duration:    100 * time.Millisecond,
+		MeanArrival: 5 * time.Millisecond,
+		RecordTrace: rec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	recorded := r.take()
+
+	if tst, err = NewTest(Params{
+		Addr:        r.addr(),
+		ReplayTrace: rec,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tst.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if replayed := r.take(); !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("replayed lengths %v, want %v", replayed, recorded)
+	}
+}
@@ -0,0 +1,98 @@
+package ccafct
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultZeroCopyLen is the default length of the memory-backed file that
+// payloads are sent from in zero-copy mode.
+const DefaultZeroCopyLen = 4 * 1024 * 1024
+
+// zeroCopySource is a memory-backed file of zeros, sent with sendfile(2) so
+// that payloads aren't copied through user space.
+type zeroCopySource struct {
+	fd  int
+	len int64
+}
+
+// newZeroCopySource returns a new zeroCopySource of the given length.
+func newZeroCopySource(length int) (z *zeroCopySource, err error) {
+	var fd int
+	if fd, err = unix.MemfdCreate("fct-payload",
+		unix.MFD_CLOEXEC); err != nil {
+		err = fmt.Errorf("memfd_create: %s", err)
+		return
+	}
+	if err = unix.Ftruncate(fd, int64(length)); err != nil {
+		unix.Close(fd)
+		err = fmt.Errorf("ftruncate memfd: %s", err)
+		return
+	}
+	z = &zeroCopySource{fd, int64(length)}
+	return
+}
+
+// Close closes the source.
+func (z *zeroCopySource) Close() error {
+	return unix.Close(z.fd)
+}
+
+// send sends n bytes of payload to the connection with sendfile, wrapping
+// around the source as needed, and records the bytes sent in f.
+func (z *zeroCopySource) send(c *net.TCPConn, n int64,
+	f *ServerFlow) (err error) {
+	var rc syscall.RawConn
+	if rc, err = c.SyscallConn(); err != nil {
+		return
+	}
+	var off int64
+	werr := rc.Write(func(fd uintptr) bool {
+		for n > 0 {
+			l := n
+			if r := z.len - off; l > r {
+				l = r
+			}
+			o := off
+			w, e := unix.Sendfile(int(fd), z.fd, &o, int(l))
+			if w > 0 {
+				f.record(w)
+				n -= int64(w)
+				off = (off + int64(w)) % z.len
+			}
+			switch {
+			case e == unix.EAGAIN:
+				return false
+			case e == unix.EINTR:
+			case e != nil:
+				err = fmt.Errorf("sendfile: %s", e)
+				return true
+			case w == 0:
+				err = io.ErrUnexpectedEOF
+				return true
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = werr
+	}
+	return
+}
+
+// threadCPU returns the CPU time used by the calling thread, which should be
+// locked to it with runtime.LockOSThread. CLOCK_THREAD_CPUTIME_ID is used
+// instead of RUSAGE_THREAD, which may only be accurate to the scheduler tick.
+func threadCPU() time.Duration {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_THREAD_CPUTIME_ID,
+		&ts); err != nil {
+		return 0
+	}
+	return time.Duration(ts.Nano())
+}
@@ -0,0 +1,71 @@
+package ccafct
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestZeroCopyFlows(t *testing.T) {
+	s := runServer(t, &Server{ZeroCopy: true, ZeroCopyLen: 4096})
+	tst, err := NewTest(Params{
+		Addr:     s.RawListener.Addr().String(),
+		Protocol: ProtocolTCP,
+		// lengths wrap around the source zero, one and several times
+		ReplayTrace: writeTrace(t, "0,1000\n0.01,4096\n0.02,100000\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tst.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lengths []int
+	for _, f := range data.Flow {
+		lengths = append(lengths, int(f.Length))
+	}
+	sort.Ints(lengths)
+	if want := []int{1000, 4096, 100000}; !reflect.DeepEqual(lengths, want) {
+		t.Errorf("flow lengths %v, want %v", lengths, want)
+	}
+}
+
+func TestFlowCPU(t *testing.T) {
+	for _, c := range []struct {
+		flowCPU bool
+		proto   string
+	}{
+		{true, ProtocolHTTP},
+		{true, ProtocolTCP},
+		{false, ProtocolHTTP},
+		{false, ProtocolTCP},
+	} {
+		s := runServer(t, &Server{FlowCPU: c.flowCPU})
+		addr := s.Listener.Addr().String()
+		if c.proto == ProtocolTCP {
+			addr = s.RawListener.Addr().String()
+		}
+		tst, err := NewTest(Params{
+			Addr:        addr,
+			Protocol:    c.proto,
+			ReplayTrace: writeTrace(t, "0,1000000\n0.01,1000000\n"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := tst.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range data.Flow {
+			if f.Server == nil {
+				t.Errorf("%s: no server flow record", c.proto)
+			} else if cpu := f.Server.CPU; (cpu > 0) != c.flowCPU {
+				t.Errorf("%s with FlowCPU %t: server CPU %s", c.proto,
+					c.flowCPU, cpu)
+			}
+		}
+	}
+}